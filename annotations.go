@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// InvalidAnnotationsReject rejects the requests whose generated annotations are not valid.
+	InvalidAnnotationsReject = "reject"
+	// InvalidAnnotationsSkip drops the invalid generated annotations and admits the request.
+	InvalidAnnotationsSkip = "skip"
+	// TotalAnnotationSizeLimit is the maximum total size of the annotations of an object
+	// accepted by the Kubernetes API server, in bytes.
+	TotalAnnotationSizeLimit = 256 * 1024
+	// qualifiedNameMaxLength is the maximum length of the name part of a qualified name.
+	qualifiedNameMaxLength = 63
+	// dnsSubdomainMaxLength is the maximum length of the prefix part of a qualified name.
+	dnsSubdomainMaxLength = 253
+	// sampleKeyValue replaces the placeholders of the annotation keys when they are validated.
+	sampleKeyValue = "sample"
+)
+
+//nolint:gochecknoglobals // Read-only patterns checked against every generated key.
+var (
+	qualifiedNamePattern = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	dnsSubdomainPattern  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// validateQualifiedName checks that a key is a valid Kubernetes qualified name:
+// an optional DNS subdomain prefix followed by '/', and a name of at most 63 characters
+// made of alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character.
+func validateQualifiedName(key string) error {
+	parts := strings.Split(key, "/")
+	var prefix, name string
+	switch len(parts) {
+	case 1:
+		name = parts[0]
+	case 2: //nolint:mnd // a prefix and a name
+		prefix, name = parts[0], parts[1]
+		if prefix == "" {
+			return errors.New("prefix part must be non-empty")
+		}
+		if len(prefix) > dnsSubdomainMaxLength {
+			return fmt.Errorf("prefix part must be no more than %d characters", dnsSubdomainMaxLength)
+		}
+		if !dnsSubdomainPattern.MatchString(prefix) {
+			return errors.New("prefix part must be a lowercase RFC 1123 subdomain, consisting of lower case " +
+				"alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character")
+		}
+	default:
+		return errors.New("a qualified name must consist of a name with an optional DNS subdomain prefix and '/'")
+	}
+
+	if name == "" {
+		return errors.New("name part must be non-empty")
+	}
+	if len(name) > qualifiedNameMaxLength {
+		return fmt.Errorf("name part must be no more than %d characters", qualifiedNameMaxLength)
+	}
+	if !qualifiedNamePattern.MatchString(name) {
+		return errors.New("name part must consist of alphanumeric characters, '-', '_' or '.', " +
+			"and must start and end with an alphanumeric character")
+	}
+	return nil
+}
+
+// validateAnnotationKey checks that an annotation key is a valid qualified name.
+func validateAnnotationKey(key string) error {
+	if err := validateQualifiedName(key); err != nil {
+		return fmt.Errorf("annotation key %q is not valid: %w", key, err)
+	}
+	return nil
+}
+
+// sampleAnnotationKeys returns the annotation keys of the settings with the placeholders replaced
+// by sample values, so that the static parts of the keys can be validated.
+func sampleAnnotationKeys(settings Settings, captureGroups []string) []string {
+	replacements := []string{ContainerPlaceholder, sampleKeyValue}
+	for _, group := range captureGroups {
+		replacements = append(replacements, "{"+group+"}", sampleKeyValue)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	var extValue interface{} = 1
+	if settings.stableExtKeys() {
+		extValue = sampleKeyValue
+	}
+	return []string{
+		replacer.Replace(settings.AnnotationBase),
+		fmt.Sprintf(replacer.Replace(settings.AnnotationExtFormat), extValue),
+	}
+}
+
+// annotationsSize returns the total size of the annotations of an object once the given ones are added.
+func annotationsSize(existing map[string]interface{}, annotations map[string]string) int {
+	size := 0
+	for key, value := range existing {
+		if _, overwritten := annotations[key]; overwritten {
+			continue
+		}
+		size += len(key)
+		if text, ok := value.(string); ok {
+			size += len(text)
+		}
+	}
+	for key, value := range annotations {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// sortedKeys returns the keys of a map, sorted.
+func sortedKeys(entries map[string]string) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validEntries returns the entries whose key is a valid qualified name. The invalid keys of the given
+// kind, annotation or label, are dropped in skip mode and reported as an error otherwise.
+func validEntries(entries map[string]string, kind string, skip bool) (map[string]string, error) {
+	valid := make(map[string]string, len(entries))
+	for _, key := range sortedKeys(entries) {
+		if err := validateQualifiedName(key); err != nil {
+			err = fmt.Errorf("%s key %q is not valid: %w", kind, key, err)
+			if !skip {
+				return nil, err
+			}
+			logger.WarnWith("skipping invalid "+kind).String("key", key).Err("error", err).Write()
+			continue
+		}
+		valid[key] = entries[key]
+	}
+	return valid, nil
+}
+
+// checkAnnotations validates the keys of the generated annotations and the total size of the annotations
+// of the object they are added to. In skip mode the invalid annotations are dropped, or all of them
+// when the size limit would be exceeded; in reject mode an error describing the problem is returned.
+func checkAnnotations(
+	annotations map[string]string,
+	metadata map[string]interface{},
+	settings Settings,
+) (map[string]string, error) {
+	skip := settings.InvalidAnnotations == InvalidAnnotationsSkip
+
+	valid, err := validEntries(annotations, "annotation", skip)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, _ := metadata["annotations"].(map[string]interface{})
+	if size := annotationsSize(existing, valid); size > TotalAnnotationSizeLimit {
+		err = fmt.Errorf("annotations are too long: %d bytes, must have at most %d bytes",
+			size, TotalAnnotationSizeLimit)
+		if !skip {
+			return nil, err
+		}
+		logger.WarnWith("skipping all the annotations").Int("size", size).Err("error", err).Write()
+		return map[string]string{}, nil
+	}
+	return valid, nil
+}
+
+// checkLabels validates the keys of the generated labels. In skip mode the invalid labels are dropped,
+// in reject mode an error describing the problem is returned. The label values are always valid
+// since they are sanitized.
+func checkLabels(labels map[string]string, settings Settings) (map[string]string, error) {
+	return validEntries(labels, "label", settings.InvalidAnnotations == InvalidAnnotationsSkip)
+}
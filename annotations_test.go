@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestValidateQualifiedName(t *testing.T) {
+	tests := []struct {
+		key           string
+		expectedError string
+	}{
+		{"co_elastic_logs_path", ""},
+		{"co.elastic.logs/path", ""},
+		{"example.com/Log-Path.1", ""},
+		{strings.Repeat("a", 63), ""},
+		{strings.Repeat("a", 64), "name part must be no more than 63 characters"},
+		{"a/b/c", "a qualified name must consist of a name with an optional DNS subdomain prefix and '/'"},
+		{"/path", "prefix part must be non-empty"},
+		{"example.com/", "name part must be non-empty"},
+		{strings.Repeat("a", 254) + "/path", "prefix part must be no more than 253 characters"},
+		{
+			"Example.com/path",
+			"prefix part must be a lowercase RFC 1123 subdomain, consisting of lower case alphanumeric " +
+				"characters, '-' or '.', and must start and end with an alphanumeric character",
+		},
+		{
+			"log path",
+			"name part must consist of alphanumeric characters, '-', '_' or '.', " +
+				"and must start and end with an alphanumeric character",
+		},
+		{
+			"log_path_",
+			"name part must consist of alphanumeric characters, '-', '_' or '.', " +
+				"and must start and end with an alphanumeric character",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			err := validateQualifiedName(test.key)
+			if test.expectedError == "" {
+				if err != nil {
+					t.Errorf("Expected key to be valid, got error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestAnnotationKeySettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      Settings
+		expectedError string
+	}{
+		{
+			name: "placeholders are replaced before validation",
+			settings: Settings{
+				AnnotationBase:      "logs.example.com/{container}",
+				AnnotationExtFormat: "logs.example.com/{container}-%d",
+				ContainerStrategy:   ContainerStrategyPerContainer,
+			},
+		},
+		{
+			name:     "invalid base key",
+			settings: Settings{AnnotationBase: "log path", AnnotationExtFormat: "log_path_%d"},
+			expectedError: "annotation key \"log path\" is not valid: name part must consist of alphanumeric " +
+				"characters, '-', '_' or '.', and must start and end with an alphanumeric character",
+		},
+		{
+			name:     "invalid extended key",
+			settings: Settings{AnnotationBase: "log_path", AnnotationExtFormat: "Logs.example.com/path-%d"},
+			expectedError: "annotation key \"Logs.example.com/path-1\" is not valid: prefix part must be a " +
+				"lowercase RFC 1123 subdomain, consisting of lower case alphanumeric characters, '-' or '.', " +
+				"and must start and end with an alphanumeric character",
+		},
+		{
+			name: "invalid template key",
+			settings: Settings{
+				TemplateMode:        TemplateModeGoTemplate,
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_{{.Seq}}_",
+			},
+			expectedError: "annotation key \"log_path_1_\" is not valid: name part must consist of alphanumeric " +
+				"characters, '-', '_' or '.', and must start and end with an alphanumeric character",
+		},
+		{
+			name: "invalid additional annotation key",
+			settings: Settings{
+				AnnotationBase:        "log_path",
+				AnnotationExtFormat:   "log_path_%d",
+				AdditionalAnnotations: map[string]interface{}{"a/b/c": "value"},
+			},
+			expectedError: "additional_annotations: annotation key \"a/b/c\" is not valid: " +
+				"a qualified name must consist of a name with an optional DNS subdomain prefix and '/'",
+		},
+		{
+			name: "unknown invalid_annotations mode",
+			settings: Settings{
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_%d",
+				InvalidAnnotations:  "ignore",
+			},
+			expectedError: "invalid_annotations must be either reject or skip",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.settings.EnvKey = "LOG_PATH"
+
+			valid, err := test.settings.Valid()
+			if test.expectedError == "" {
+				if !valid {
+					t.Errorf("Expected settings to be valid, got error: %v", err)
+				}
+				return
+			}
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+// invalidAnnotationsPodRequest returns a request for a Pod whose LOG_PATH_* variables are turned
+// into annotation keys, with the given error variable name and existing annotations.
+func invalidAnnotationsPodRequest(
+	invalidAnnotations string,
+	errorEnvName string,
+	existing map[string]string,
+) kubewarden_protocol.ValidationRequest {
+	settings := Settings{
+		EnvKey:              "LOG_PATH_",
+		EnvKeyMatch:         EnvKeyMatchPrefix,
+		AnnotationBase:      "logs.example.com/{suffix}",
+		AnnotationExtFormat: "logs.example.com/{suffix}-%d",
+		InvalidAnnotations:  invalidAnnotations,
+	}
+	withExisting := func(pod *corev1.Pod) {
+		pod.Metadata.Annotations = existing
+	}
+	return podRequest(settings, withExisting,
+		&corev1.EnvVar{Name: stringPtr("LOG_PATH_ACCESS"), Value: "/var/log/access.log"},
+		&corev1.EnvVar{Name: stringPtr(errorEnvName), Value: "/var/log/error.log"},
+	)
+}
+
+func TestInvalidGeneratedAnnotations(t *testing.T) {
+	response, err := validateTest(t, invalidAnnotationsPodRequest(InvalidAnnotationsReject, "LOG_PATH_ERROR_", nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedMessage := "annotation key \"logs.example.com/error_\" is not valid: name part must consist of " +
+		"alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character"
+	assertRejection(t, response, expectedMessage)
+
+	response, err = validateTest(t, invalidAnnotationsPodRequest(InvalidAnnotationsSkip, "LOG_PATH_ERROR_", nil))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertMutation(t, response, map[string]string{"logs.example.com/access": "/var/log/access.log"})
+}
+
+func TestAnnotationsSizeLimit(t *testing.T) {
+	existing := map[string]string{"example.com/large": strings.Repeat("x", TotalAnnotationSizeLimit-30)}
+
+	response, err := validateTest(t, invalidAnnotationsPodRequest(InvalidAnnotationsReject, "LOG_PATH_ERROR", existing))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedMessage := "annotations are too long: 262213 bytes, must have at most 262144 bytes"
+	assertRejection(t, response, expectedMessage)
+
+	response, err = validateTest(t, invalidAnnotationsPodRequest(InvalidAnnotationsSkip, "LOG_PATH_ERROR", existing))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// OutcomeAlways applies conditional annotations whether log paths were found or not.
+	OutcomeAlways = "always"
+	// OutcomePathFound applies conditional annotations only when at least one log path was found.
+	OutcomePathFound = "path_found"
+	// OutcomeNoMatch applies conditional annotations only along with the no_match fallback annotations.
+	OutcomeNoMatch = "no_match"
+)
+
+// AnnotationCondition restricts the objects receiving a group of conditional annotations.
+// Every condition that is set has to be met.
+type AnnotationCondition struct {
+	// Outcome is either always (default), path_found or no_match.
+	Outcome string `json:"outcome,omitempty"`
+	// Kinds restricts the annotations to the objects of these kinds, such as Pod or Deployment.
+	Kinds []string `json:"kinds,omitempty"`
+	// Namespaces restricts the annotations to the objects of these namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Containers restricts the annotations to the objects where a log path was found in one of
+	// these containers.
+	Containers []string `json:"containers,omitempty"`
+}
+
+// ConditionalAnnotations is a group of additional annotations added only when its conditions are met.
+type ConditionalAnnotations struct {
+	// When holds the conditions of the group.
+	When AnnotationCondition `json:"when"`
+	// Annotations are the custom key-value pairs of the group.
+	Annotations map[string]interface{} `json:"annotations"`
+}
+
+// Valid validates the conditions.
+func (c *AnnotationCondition) Valid() error {
+	switch c.Outcome {
+	case "", OutcomeAlways, OutcomePathFound, OutcomeNoMatch:
+	default:
+		return fmt.Errorf("outcome must be one of %s, %s, %s", OutcomeAlways, OutcomePathFound, OutcomeNoMatch)
+	}
+	if c.Outcome == OutcomeNoMatch && len(c.Containers) > 0 {
+		return errors.New("containers cannot be set when outcome is " + OutcomeNoMatch)
+	}
+
+	for _, list := range []struct {
+		name    string
+		entries []string
+	}{
+		{"kinds", c.Kinds},
+		{"namespaces", c.Namespaces},
+		{"containers", c.Containers},
+	} {
+		for _, entry := range list.entries {
+			if strings.TrimSpace(entry) == "" {
+				return fmt.Errorf("%s entries cannot be empty", list.name)
+			}
+		}
+	}
+	return nil
+}
+
+// Valid validates the group of conditional annotations found at the given index.
+func (c *ConditionalAnnotations) Valid(index int) error {
+	name := fmt.Sprintf("conditional_annotations[%d]", index)
+	if len(c.Annotations) == 0 {
+		return errors.New(name + " annotations cannot be empty")
+	}
+	if err := validAdditionalValues(name+" annotations", c.Annotations); err != nil {
+		return err
+	}
+	if err := c.When.Valid(); err != nil {
+		return fmt.Errorf("%s when: %w", name, err)
+	}
+	return nil
+}
+
+// validAdditionalValues validates the keys and values of the custom annotations of the given setting.
+func validAdditionalValues(name string, annotations map[string]interface{}) error {
+	for key, value := range annotations {
+		if key == "" {
+			return errors.New(name + " keys cannot be empty")
+		}
+		if err := validateAnnotationKey(key); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		// Allow boolean, numeric, object and array values
+		// Only check for emptiness if the value is a string
+		if strVal, ok := value.(string); ok && strVal == "" {
+			return errors.New(name + " string values cannot be empty")
+		}
+		if err := validateValueType(value); err != nil {
+			return fmt.Errorf("%s %s: %w", name, key, err)
+		}
+	}
+	return nil
+}
+
+// matches checks if the conditions are met by an object with the given log paths.
+func (c *AnnotationCondition) matches(logPaths []logPath, info requestInfo) bool {
+	switch c.Outcome {
+	case OutcomePathFound:
+		if len(logPaths) == 0 {
+			return false
+		}
+	case OutcomeNoMatch:
+		if len(logPaths) > 0 {
+			return false
+		}
+	}
+
+	if len(c.Kinds) > 0 && !slices.ContainsFunc(c.Kinds, func(kind string) bool {
+		return strings.EqualFold(kind, info.Kind)
+	}) {
+		return false
+	}
+	if len(c.Namespaces) > 0 && !slices.Contains(c.Namespaces, info.Namespace) {
+		return false
+	}
+	if len(c.Containers) > 0 && !slices.ContainsFunc(logPaths, func(path logPath) bool {
+		return slices.Contains(c.Containers, path.Container)
+	}) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConditionalAnnotations(t *testing.T) {
+	multiline := ConditionalAnnotations{
+		When:        AnnotationCondition{Outcome: OutcomePathFound},
+		Annotations: map[string]interface{}{"co.elastic.logs/multiline.type": "pattern"},
+	}
+	stdout := ConditionalAnnotations{
+		When:        AnnotationCondition{Outcome: OutcomeNoMatch},
+		Annotations: map[string]interface{}{"logs.example.com/stdout": true},
+	}
+
+	tests := []struct {
+		name                string
+		fixture             string
+		templatePath        []string
+		conditional         []ConditionalAnnotations
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:        "path found",
+			fixture:     "test_data/pod-single-env.json",
+			conditional: []ConditionalAnnotations{multiline, stdout},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":           "/var/log/app.log",
+				"co.elastic.logs/multiline.type": "pattern",
+			},
+		},
+		{
+			name:        "no match",
+			fixture:     "test_data/pod-no-env.json",
+			conditional: []ConditionalAnnotations{multiline, stdout},
+			expectedAnnotations: map[string]string{
+				LogEnabledAnnotation:      LogEnabledValue,
+				"logs.example.com/stdout": "true",
+			},
+		},
+		{
+			name:    "kinds",
+			fixture: "test_data/pod-single-env.json",
+			conditional: []ConditionalAnnotations{
+				{
+					When:        AnnotationCondition{Kinds: []string{"deployment"}},
+					Annotations: map[string]interface{}{"example.com/workload": "true"},
+				},
+				{
+					When:        AnnotationCondition{Kinds: []string{"pod"}},
+					Annotations: map[string]interface{}{"example.com/pod": "true"},
+				},
+			},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path": "/var/log/app.log",
+				"example.com/pod":      "true",
+			},
+		},
+		{
+			name:         "namespaces and containers",
+			fixture:      "test_data/deployment-env.json",
+			templatePath: []string{"spec", "template"},
+			conditional: []ConditionalAnnotations{
+				{
+					When:        AnnotationCondition{Namespaces: []string{"default"}, Containers: []string{"nginx"}},
+					Annotations: map[string]interface{}{"example.com/nginx": "true"},
+				},
+				{
+					When:        AnnotationCondition{Namespaces: []string{"kube-system"}},
+					Annotations: map[string]interface{}{"example.com/system": "true"},
+				},
+				{
+					When:        AnnotationCondition{Containers: []string{"sidecar"}},
+					Annotations: map[string]interface{}{"example.com/sidecar": "true"},
+				},
+			},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/err.log",
+				"example.com/nginx":          "true",
+			},
+		},
+		{
+			name:    "later groups take precedence",
+			fixture: "test_data/pod-single-env.json",
+			conditional: []ConditionalAnnotations{
+				{Annotations: map[string]interface{}{"example.com/tier": "default"}},
+				{
+					When:        AnnotationCondition{Containers: []string{"nginx"}},
+					Annotations: map[string]interface{}{"example.com/tier": "web"},
+				},
+			},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path": "/var/log/app.log",
+				"example.com/tier":     "web",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:                 "vestack_varlog",
+				AnnotationBase:         "co_elastic_logs_path",
+				AnnotationExtFormat:    "co_elastic_logs_path_ext_%d",
+				ConditionalAnnotations: test.conditional,
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid, got: %v", err)
+			}
+
+			response := validateFixture(t, test.fixture, settings)
+			if test.templatePath == nil {
+				assertMutation(t, response, test.expectedAnnotations)
+				return
+			}
+
+			annotations := mutatedMap(t, response, append(test.templatePath, "metadata", "annotations")...)
+			for key, expectedValue := range test.expectedAnnotations {
+				if annotations[key] != expectedValue {
+					t.Errorf("Expected annotation %s to be %s, got %v", key, expectedValue, annotations[key])
+				}
+			}
+			if len(annotations) != len(test.expectedAnnotations) {
+				t.Errorf("Expected %d annotations, got %v", len(test.expectedAnnotations), annotations)
+			}
+		})
+	}
+}
+
+func TestConditionalAnnotationsTemplates(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_{{ .Seq }}",
+		TemplateMode:        TemplateModeGoTemplate,
+		ConditionalAnnotations: []ConditionalAnnotations{
+			{
+				When:        AnnotationCondition{Outcome: OutcomePathFound},
+				Annotations: map[string]interface{}{"example.com/source": "{{ .Kind }}"},
+			},
+		},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Expected settings to be valid, got: %v", err)
+	}
+
+	response := validateFixture(t, "test_data/pod-single-env.json", settings)
+	assertMutation(t, response, map[string]string{
+		"co_elastic_logs_path": "/var/log/app.log",
+		"example.com/source":   "Pod",
+	})
+
+	settings.ConditionalAnnotations[0].Annotations["example.com/source"] = "{{ .Owner }}"
+	valid, err := settings.Valid()
+	if valid || err == nil {
+		t.Errorf("Expected settings with an invalid conditional template to be invalid")
+	}
+}
+
+func TestConditionalAnnotationsSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		conditional   ConditionalAnnotations
+		expectedError string
+	}{
+		{
+			"no annotations",
+			ConditionalAnnotations{When: AnnotationCondition{Outcome: OutcomePathFound}},
+			"conditional_annotations[0] annotations cannot be empty",
+		},
+		{
+			"empty value",
+			ConditionalAnnotations{Annotations: map[string]interface{}{"example.com/team": ""}},
+			"conditional_annotations[0] annotations string values cannot be empty",
+		},
+		{
+			"invalid key",
+			ConditionalAnnotations{Annotations: map[string]interface{}{"team/": "platform"}},
+			"conditional_annotations[0] annotations: annotation key \"team/\" is not valid: name part must be non-empty",
+		},
+		{
+			"unknown outcome",
+			ConditionalAnnotations{
+				When:        AnnotationCondition{Outcome: "found"},
+				Annotations: map[string]interface{}{"example.com/team": "platform"},
+			},
+			"conditional_annotations[0] when: outcome must be one of always, path_found, no_match",
+		},
+		{
+			"containers on no match",
+			ConditionalAnnotations{
+				When:        AnnotationCondition{Outcome: OutcomeNoMatch, Containers: []string{"app"}},
+				Annotations: map[string]interface{}{"example.com/team": "platform"},
+			},
+			"conditional_annotations[0] when: containers cannot be set when outcome is no_match",
+		},
+		{
+			"empty namespace",
+			ConditionalAnnotations{
+				When:        AnnotationCondition{Namespaces: []string{" "}},
+				Annotations: map[string]interface{}{"example.com/team": "platform"},
+			},
+			"conditional_annotations[0] when: namespaces entries cannot be empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:                 "LOG_PATH",
+				AnnotationBase:         "log_path",
+				AnnotationExtFormat:    "log_path_%d",
+				ConditionalAnnotations: []ConditionalAnnotations{test.conditional},
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
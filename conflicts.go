@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ConflictOverwrite replaces the existing value with the generated one.
+	ConflictOverwrite = "overwrite"
+	// ConflictKeep keeps the existing value.
+	ConflictKeep = "keep"
+	// ConflictFail rejects the request.
+	ConflictFail = "fail"
+	// ConflictMerge merges the existing and the generated values as lists.
+	ConflictMerge = "merge"
+)
+
+// validConflictStrategy validates a conflict strategy of the given setting.
+func validConflictStrategy(name, strategy string) error {
+	switch strategy {
+	case "", ConflictOverwrite, ConflictKeep, ConflictFail, ConflictMerge:
+		return nil
+	default:
+		return fmt.Errorf("%s must be one of %s, %s, %s, %s",
+			name, ConflictOverwrite, ConflictKeep, ConflictFail, ConflictMerge)
+	}
+}
+
+// conflictStrategy returns the strategy used when the given key already exists on the object.
+func (s *Settings) conflictStrategy(key string) string {
+	if strategy, ok := s.ConflictStrategies[key]; ok && strategy != "" {
+		return strategy
+	}
+	if s.ConflictStrategy == "" {
+		return ConflictOverwrite
+	}
+	return s.ConflictStrategy
+}
+
+// mergeListValues merges two values holding lists, keeping the existing entries first and
+// dropping the duplicates. Values written as JSON arrays of strings are merged as JSON arrays,
+// the other ones as comma separated lists.
+func mergeListValues(existing, generated string) string {
+	var existingEntries, generatedEntries []string
+	existingErr := json.Unmarshal([]byte(existing), &existingEntries)
+	generatedErr := json.Unmarshal([]byte(generated), &generatedEntries)
+	asJSON := existingErr == nil && generatedErr == nil
+	if !asJSON {
+		existingEntries = splitOnSeparators(existing, []string{defaultListSeparator})
+		generatedEntries = splitOnSeparators(generated, []string{defaultListSeparator})
+	}
+
+	seen := make(map[string]bool)
+	merged := make([]string, 0, len(existingEntries)+len(generatedEntries))
+	for _, entry := range append(existingEntries, generatedEntries...) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		merged = append(merged, entry)
+	}
+
+	if asJSON {
+		encoded, err := json.Marshal(merged)
+		if err == nil {
+			return string(encoded)
+		}
+	}
+	return strings.Join(merged, defaultListSeparator)
+}
+
+// resolveConflicts applies the conflict strategies to the generated entries of the given kind,
+// annotation or label, whose key already exists on the object with a different value.
+// It returns the entries to write, or an error naming the conflicting key for the fail strategy.
+func resolveConflicts(
+	generated map[string]string,
+	existing map[string]interface{},
+	kind string,
+	settings Settings,
+) (map[string]string, error) {
+	resolved := make(map[string]string, len(generated))
+	for _, key := range sortedKeys(generated) {
+		value := generated[key]
+		current, found := existing[key]
+		if !found || convertToString(current) == value {
+			resolved[key] = value
+			continue
+		}
+
+		switch settings.conflictStrategy(key) {
+		case ConflictKeep:
+			continue
+		case ConflictFail:
+			return nil, fmt.Errorf("%s %s is already set to %q, refusing to replace it with %q",
+				kind, key, convertToString(current), value)
+		case ConflictMerge:
+			if kind == "label" {
+				// Label values cannot hold lists
+				resolved[key] = value
+				continue
+			}
+			resolved[key] = mergeListValues(convertToString(current), value)
+		default:
+			resolved[key] = value
+		}
+	}
+	return resolved, nil
+}
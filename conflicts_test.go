@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+// validateFixtureWithAnnotations executes the policy against a fixture whose object is given
+// the existing annotations at the metadata found at the given path.
+func validateFixtureWithAnnotations(
+	t *testing.T,
+	fixture string,
+	metadataPath []string,
+	existing map[string]interface{},
+	settings Settings,
+) *kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	return validateModifiedFixture(t, fixture, withAnnotationsAt(t, metadataPath, existing), settings)
+}
+
+func TestConflictStrategies(t *testing.T) {
+	objects := []struct {
+		fixture      string
+		metadataPath []string
+	}{
+		{"test_data/pod-multiple-containers.json", []string{"metadata"}},
+		{"test_data/deployment-env.json", []string{"spec", "template", "metadata"}},
+	}
+
+	tests := []struct {
+		name              string
+		strategy          string
+		strategies        map[string]string
+		existingValue     string
+		expectedValue     string
+		expectedRejection string
+	}{
+		{"default overwrites", "", nil, "/var/log/custom.log", "/var/log/app.log", ""},
+		{"overwrite", ConflictOverwrite, nil, "/var/log/custom.log", "/var/log/app.log", ""},
+		{"keep", ConflictKeep, nil, "/var/log/custom.log", "/var/log/custom.log", ""},
+		{
+			"fail", ConflictFail, nil, "/var/log/custom.log", "",
+			"annotation co_elastic_logs_path is already set to \"/var/log/custom.log\", " +
+				"refusing to replace it with \"/var/log/app.log\"",
+		},
+		{"fail ignores equal values", ConflictFail, nil, "/var/log/app.log", "/var/log/app.log", ""},
+		{"merge", ConflictMerge, nil, "/var/log/custom.log", "/var/log/custom.log,/var/log/app.log", ""},
+		{"merge drops duplicates", ConflictMerge, nil, "/var/log/app.log,/var/log/custom.log",
+			"/var/log/app.log,/var/log/custom.log", ""},
+		{
+			"per key strategy", ConflictFail, map[string]string{"co_elastic_logs_path": ConflictKeep},
+			"/var/log/custom.log", "/var/log/custom.log", "",
+		},
+	}
+
+	for _, object := range objects {
+		for _, test := range tests {
+			t.Run(object.fixture+"/"+test.name, func(t *testing.T) {
+				settings := Settings{
+					EnvKey:              "vestack_varlog",
+					AnnotationBase:      "co_elastic_logs_path",
+					AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+					ConflictStrategy:    test.strategy,
+					ConflictStrategies:  test.strategies,
+				}
+				existing := map[string]interface{}{
+					"co_elastic_logs_path": test.existingValue,
+					"example.com/owner":    "team-a",
+				}
+
+				response := validateFixtureWithAnnotations(t, object.fixture, object.metadataPath, existing, settings)
+				if test.expectedRejection != "" {
+					assertRejection(t, response, test.expectedRejection)
+					return
+				}
+
+				annotations := mutatedMap(t, response, append(object.metadataPath, "annotations")...)
+				if annotations["co_elastic_logs_path"] != test.expectedValue {
+					t.Errorf("Expected annotation co_elastic_logs_path to be %s, got %v",
+						test.expectedValue, annotations["co_elastic_logs_path"])
+				}
+				if annotations["example.com/owner"] != "team-a" {
+					t.Errorf("Expected unrelated annotations to be preserved, got %v", annotations)
+				}
+			})
+		}
+	}
+}
+
+func TestMergeListValues(t *testing.T) {
+	tests := []struct {
+		existing      string
+		generated     string
+		expectedValue string
+	}{
+		{"/a.log", "/b.log", "/a.log,/b.log"},
+		{"/a.log, /b.log", "/b.log,/c.log", "/a.log,/b.log,/c.log"},
+		{`["/a.log","/b.log"]`, `["/b.log","/c.log"]`, `["/a.log","/b.log","/c.log"]`},
+		{`["/a.log"]`, "/b.log", `["/a.log"],/b.log`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.existing, func(t *testing.T) {
+			merged := mergeListValues(test.existing, test.generated)
+			if merged != test.expectedValue {
+				t.Errorf("Expected %s, got %s", test.expectedValue, merged)
+			}
+		})
+	}
+}
+
+func TestConflictStrategySettings(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "log_path",
+		AnnotationExtFormat: "log_path_%d",
+		ConflictStrategy:    "replace",
+	}
+	valid, err := settings.Valid()
+	expectedError := "conflict_strategy must be one of overwrite, keep, fail, merge"
+	if valid || err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+
+	settings.ConflictStrategy = ConflictKeep
+	settings.ConflictStrategies = map[string]string{"log_path": "append"}
+	valid, err = settings.Valid()
+	expectedError = "conflict_strategies log_path must be one of overwrite, keep, fail, merge"
+	if valid || err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+}
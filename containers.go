@@ -0,0 +1,120 @@
+package main
+
+import (
+	"regexp"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+// logPath is a log path discovered in the environment of a container.
+type logPath struct {
+	// Container is the name of the container declaring the log path.
+	Container string
+	// Path is the log path itself.
+	Path string
+	// Env is the name of the environment variable declaring the log path.
+	Env string
+	// Captures holds the capture groups of the env_key match.
+	Captures map[string]string
+}
+
+// containerNamePattern compiles a container_include/container_exclude entry.
+// Entries are regular expressions matched against the whole container name,
+// so plain container names only match themselves.
+func containerNamePattern(pattern string) (*regexp.Regexp, error) {
+	return compileSettingsPattern("^(?:" + pattern + ")$")
+}
+
+// containerNamePatterns returns the compiled container_include/container_exclude entries,
+// skipping the invalid ones.
+func containerNamePatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := containerNamePattern(pattern)
+		if err != nil {
+			logger.Warn("ignoring invalid container pattern " + pattern)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// matchesAnyContainerName checks if the container name matches one of the given patterns.
+func matchesAnyContainerName(name string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedContainers returns the containers of a pod spec whose environment has to be checked.
+// Init containers are returned after the regular ones, only when enabled. Ephemeral debug
+// containers are always skipped: they are added to running Pods and never write application logs.
+func selectedContainers(spec *corev1.PodSpec, settings Settings) []*corev1.Container {
+	containers := make([]*corev1.Container, 0, len(spec.Containers)+len(spec.InitContainers))
+	containers = append(containers, spec.Containers...)
+	if settings.IncludeInitContainers {
+		containers = append(containers, spec.InitContainers...)
+	}
+
+	include := containerNamePatterns(settings.ContainerInclude)
+	exclude := containerNamePatterns(settings.ContainerExclude)
+	var selected []*corev1.Container
+	for _, container := range containers {
+		if container == nil {
+			continue
+		}
+
+		var name string
+		if container.Name != nil {
+			name = *container.Name
+		}
+		if len(settings.ContainerInclude) > 0 && !matchesAnyContainerName(name, include) {
+			continue
+		}
+		if matchesAnyContainerName(name, exclude) {
+			continue
+		}
+		selected = append(selected, container)
+	}
+	return selected
+}
+
+// collectLogPaths checks the environment variables of the selected containers of a pod spec
+// and returns the log paths found, in declaration order, once checked against the path policy.
+func collectLogPaths(spec *corev1.PodSpec, settings Settings, resolver *valueResolver) ([]logPath, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	matcher, err := newEnvKeyMatcher(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var logPaths []logPath
+	for _, container := range selectedContainers(spec, settings) {
+		var name string
+		if container.Name != nil {
+			name = *container.Name
+		}
+		values, checkErr := checkEnvVars(container, matcher, resolver)
+		if checkErr != nil {
+			return nil, checkErr
+		}
+		for _, value := range values {
+			for _, path := range splitLogPaths(value.Value, settings.ValueParsing) {
+				logPaths = append(logPaths, logPath{
+					Container: name,
+					Path:      path,
+					Env:       value.Name,
+					Captures:  value.Captures,
+				})
+			}
+		}
+	}
+	return applyPathPolicy(logPaths, settings.PathPolicy)
+}
@@ -143,3 +143,79 @@
     ]' 
   [ $? -eq 0 ]
 }
+
+@test "Pod with comma separated paths in a single env variable is mutated with base and extended annotations" {
+  run kwctl run \
+    -r "test_data/pod-comma-env.json" \
+    --settings-json '{ "env_key": "vestack_varlog", "annotation_base": "co_elastic_logs_path", "annotation_ext_format": "co_elastic_logs_path_ext_%d" }' \
+    "annotated-policy.wasm"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *'"allowed":true'* ]]
+  [[ "$output" == *'"patch"'* ]]
+  patch_b64=$(echo "$output" | tail -n 1 | jq -r '.patch')
+  patch_decoded=$(echo "$patch_b64" | base64 --decode)
+  echo "Decoded Patch (Comma Separated Env): $patch_decoded"
+  echo "$patch_decoded" | jq -e '.[] | select(.op == "add" and .path == "/metadata/annotations" and .value.co_elastic_logs_path == "/var/log/app.log" and .value.co_elastic_logs_path_ext_1 == "/var/log/err.log")'
+  [ $? -eq 0 ]
+}
+
+@test "Pod with no target env variable is not mutated when no_match is ignore" {
+  run kwctl run \
+    -r "test_data/pod-no-env.json" \
+    --settings-json '{ "env_key": "vestack_varlog", "annotation_base": "co_elastic_logs_path", "annotation_ext_format": "co_elastic_logs_path_ext_%d", "no_match": { "action": "ignore" } }' \
+    "annotated-policy.wasm"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *'"allowed":true'* ]]
+  [[ "$output" != *'"patch"'* ]]
+}
+
+@test "Pod with no target env variable is rejected when no_match is reject" {
+  run kwctl run \
+    -r "test_data/pod-no-env.json" \
+    --settings-json '{ "env_key": "vestack_varlog", "annotation_base": "co_elastic_logs_path", "annotation_ext_format": "co_elastic_logs_path_ext_%d", "no_match": { "action": "reject" } }' \
+    "annotated-policy.wasm"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *'"allowed":false'* ]]
+  [[ "$output" == *'no container defines the environment variable vestack_varlog'* ]]
+}
+
+@test "Pod already carrying the generated annotations is accepted without a patch" {
+  run kwctl run \
+    -r "test_data/pod-single-env-annotated.json" \
+    --settings-json '{ "env_key": "vestack_varlog", "annotation_base": "co_elastic_logs_path", "annotation_ext_format": "co_elastic_logs_path_ext_%d" }' \
+    "annotated-policy.wasm"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *'"allowed":true'* ]]
+  [[ "$output" != *'"patch"'* ]]
+}
+
+@test "Pod is mutated with go_template annotation keys and values" {
+  run kwctl run \
+    -r "test_data/pod-multiple-containers.json" \
+    --settings-json '{ "env_key": "vestack_varlog", "template_mode": "go_template", "container_strategy": "per_container", "annotation_base": "logs.example.com/{{.Container}}", "annotation_ext_format": "logs.example.com/{{.Container}}-{{.Seq}}", "additional_annotations": { "logs.example.com/source": "{{.Kind}}/{{.Name}}" } }' \
+    "annotated-policy.wasm"
+
+  [ "$status" -eq 0 ]
+  [[ "$output" == *'"allowed":true'* ]]
+  [[ "$output" == *'"patch"'* ]]
+  patch_b64=$(echo "$output" | tail -n 1 | jq -r '.patch')
+  patch_decoded=$(echo "$patch_b64" | base64 --decode)
+  echo "Decoded Patch (Go Template): $patch_decoded"
+  echo "$patch_decoded" | jq -e '.[] | select(.op == "add" and .path == "/metadata/annotations" and .value["logs.example.com/app"] == "/var/log/app.log" and .value["logs.example.com/worker-1"] == "/var/log/worker-err.log" and .value["logs.example.com/source"] == "Pod/multi")'
+  [ $? -eq 0 ]
+}
+
+@test "Settings with go_template functions are rejected" {
+  run kwctl run \
+    -r "test_data/pod-single-env.json" \
+    --settings-json '{ "env_key": "vestack_varlog", "template_mode": "go_template", "annotation_base": "logs.example.com/{{.Container}}", "annotation_ext_format": "logs.example.com/{{.Container}}-{{printf \"%02d\" .Seq}}" }' \
+    "annotated-policy.wasm"
+
+  [ "$status" -ne 0 ]
+  [[ "$output" == *'functions are not supported, found printf'* ]]
+  [[ "$output" != *'"allowed":true'* ]]
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EncodingRaw writes strings, booleans and numbers as they are, and objects and arrays as compact JSON.
+	EncodingRaw = "raw"
+	// EncodingJSON writes every value as compact JSON, including strings.
+	EncodingJSON = "json"
+	// EncodingYAML writes objects and arrays as block YAML.
+	EncodingYAML = "yaml"
+	// EncodingBase64 writes the raw encoding of the value in base64.
+	EncodingBase64 = "base64"
+	// yamlIndent is the indentation of the nested YAML blocks.
+	yamlIndent = "  "
+)
+
+// validEncoding validates the encoding of the given annotation key.
+func validEncoding(key, encoding string) error {
+	switch encoding {
+	case "", EncodingRaw, EncodingJSON, EncodingYAML, EncodingBase64:
+		return nil
+	default:
+		return fmt.Errorf("value_encodings %s must be one of %s, %s, %s, %s",
+			key, EncodingRaw, EncodingJSON, EncodingYAML, EncodingBase64)
+	}
+}
+
+// validateValueType checks that a custom annotation value, and every value nested in it,
+// is of a type that can be encoded.
+func validateValueType(value interface{}) error {
+	switch v := value.(type) {
+	case nil, string, bool, int, int32, int64, float32, float64, json.Number:
+		return nil
+	case map[string]interface{}:
+		for _, nested := range v {
+			if err := validateValueType(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		for _, nested := range v {
+			if err := validateValueType(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// encodeValue encodes a custom annotation value with the given encoding, raw by default.
+func encodeValue(value interface{}, encoding string) (string, error) {
+	switch encoding {
+	case EncodingJSON:
+		return marshalCompactJSON(value)
+	case EncodingYAML:
+		var encoded strings.Builder
+		if err := writeYAML(&encoded, value, ""); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(encoded.String(), "\n"), nil
+	case EncodingBase64:
+		raw, err := encodeValue(value, EncodingRaw)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(raw)), nil
+	default:
+		if scalar, ok := scalarString(value); ok {
+			return scalar, nil
+		}
+		return marshalCompactJSON(value)
+	}
+}
+
+// scalarString returns the string form of a scalar value. Numbers are written in decimal notation
+// so that large values are not turned into exponents.
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// marshalCompactJSON encodes a value as compact JSON, without escaping the HTML characters.
+func marshalCompactJSON(value interface{}) (string, error) {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", fmt.Errorf("cannot encode value as JSON: %w", err)
+	}
+	return strings.TrimSuffix(encoded.String(), "\n"), nil
+}
+
+// writeYAML writes a value as block YAML with the given indentation. The keys of the objects are sorted.
+func writeYAML(out *strings.Builder, value interface{}, indent string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			out.WriteString("{}\n")
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			out.WriteString(indent + yamlScalar(key) + ":")
+			if err := writeYAMLChild(out, v[key], indent); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			out.WriteString("[]\n")
+			return nil
+		}
+		for _, item := range v {
+			out.WriteString(indent + "-")
+			if err := writeYAMLChild(out, item, indent); err != nil {
+				return err
+			}
+		}
+	default:
+		scalar, ok := scalarString(value)
+		if !ok {
+			return fmt.Errorf("unsupported value type %T", value)
+		}
+		if _, isString := value.(string); isString {
+			scalar = yamlScalar(scalar)
+		} else if value == nil {
+			scalar = "null"
+		}
+		out.WriteString(indent + scalar + "\n")
+	}
+	return nil
+}
+
+// writeYAMLChild writes the value of an object key or of an array item: non-empty objects and
+// arrays go to a nested block, the other values on the same line.
+func writeYAMLChild(out *strings.Builder, value interface{}, indent string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) > 0 {
+			out.WriteString("\n")
+			return writeYAML(out, v, indent+yamlIndent)
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			out.WriteString("\n")
+			return writeYAML(out, v, indent+yamlIndent)
+		}
+	}
+	out.WriteString(" ")
+	return writeYAML(out, value, "")
+}
+
+// yamlScalar quotes a string when it would not be read back as the same plain YAML string.
+func yamlScalar(value string) string {
+	switch strings.ToLower(value) {
+	case "", "~", "null", "true", "false", "yes", "no", "on", "off":
+		return strconv.Quote(value)
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return strconv.Quote(value)
+	}
+	if strings.ContainsAny(value, ":#{}[],&*!|>'\"%@`\n\t\\") ||
+		strings.HasPrefix(value, "-") || strings.HasPrefix(value, "?") ||
+		strings.TrimSpace(value) != value {
+		return strconv.Quote(value)
+	}
+	return value
+}
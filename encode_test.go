@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestEncodeValue(t *testing.T) {
+	object := map[string]interface{}{
+		"type":    "pattern",
+		"negate":  true,
+		"lines":   float64(500),
+		"matches": []interface{}{"^\\[", "<trace>"},
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		encoding string
+		expected string
+	}{
+		{"string", "value", "", "value"},
+		{"boolean", true, EncodingRaw, "true"},
+		{"integer", 42, EncodingRaw, "42"},
+		{"large number", float64(1000000), EncodingRaw, "1000000"},
+		{"decimal number", 0.25, EncodingRaw, "0.25"},
+		{"object", object, EncodingRaw, `{"lines":500,"matches":["^\\[","<trace>"],"negate":true,"type":"pattern"}`},
+		{"array", []interface{}{"a", float64(1), nil}, EncodingRaw, `["a",1,null]`},
+		{"json string", "value", EncodingJSON, `"value"`},
+		{"json number", float64(1000000), EncodingJSON, "1000000"},
+		{"yaml string", "true", EncodingYAML, `"true"`},
+		{
+			"yaml object",
+			object,
+			EncodingYAML,
+			"lines: 500\nmatches:\n  - \"^\\\\[\"\n  - \"<trace>\"\nnegate: true\ntype: pattern",
+		},
+		{
+			"yaml nested",
+			[]interface{}{map[string]interface{}{"path": "/var/log/app.log", "tags": []interface{}{}}},
+			EncodingYAML,
+			"-\n  path: /var/log/app.log\n  tags: []",
+		},
+		{"base64 string", "/var/log/app.log", EncodingBase64, "L3Zhci9sb2cvYXBwLmxvZw=="},
+		{"base64 object", map[string]interface{}{"a": float64(1)}, EncodingBase64, "eyJhIjoxfQ=="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := encodeValue(test.value, test.encoding)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if encoded != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, encoded)
+			}
+		})
+	}
+}
+
+func TestStructuredAdditionalAnnotations(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		AdditionalAnnotations: map[string]interface{}{
+			"example.com/multiline": map[string]interface{}{"type": "pattern", "max_lines": 1000000},
+			"example.com/tags":      []interface{}{"web", "frontend"},
+			"example.com/config":    map[string]interface{}{"enabled": true},
+		},
+		ValueEncodings: map[string]string{"example.com/config": EncodingBase64},
+	}
+	if valid, err := settings.Valid(); !valid {
+		t.Fatalf("Expected settings to be valid, got: %v", err)
+	}
+
+	response := validateFixture(t, "test_data/pod-single-env.json", settings)
+	assertMutation(t, response, map[string]string{
+		"co_elastic_logs_path":  "/var/log/app.log",
+		"example.com/multiline": `{"max_lines":1000000,"type":"pattern"}`,
+		"example.com/tags":      `["web","frontend"]`,
+		"example.com/config":    "eyJlbmFibGVkIjp0cnVlfQ==",
+	})
+}
+
+func TestLargeIntegerAdditionalAnnotations(t *testing.T) {
+	// 12345678901234567891 is above 2^53 and would be rounded if decoded as a float64
+	rawSettings := []byte(`{
+		"env_key": "vestack_varlog",
+		"annotation_base": "co_elastic_logs_path",
+		"annotation_ext_format": "co_elastic_logs_path_ext_%d",
+		"additional_annotations": {
+			"example.com/id": 12345678901234567891,
+			"example.com/limits": {"max_bytes": 9007199254740993}
+		}
+	}`)
+
+	response, err := validateSettings(rawSettings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var settingsResponse kubewarden_protocol.SettingsValidationResponse
+	if err = json.Unmarshal(response, &settingsResponse); err != nil {
+		t.Fatalf("Failed to unmarshal settings response: %v", err)
+	}
+	if !settingsResponse.Valid {
+		t.Fatalf("Expected settings to be valid, got: %v", settingsResponse.Message)
+	}
+
+	validationResponse, err := validateTest(t, kubewarden_protocol.ValidationRequest{
+		Request:  loadFixture(t, "test_data/pod-single-env.json", nil),
+		Settings: rawSettings,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertMutation(t, validationResponse, map[string]string{
+		"co_elastic_logs_path": "/var/log/app.log",
+		"example.com/id":       "12345678901234567891",
+		"example.com/limits":   `{"max_bytes":9007199254740993}`,
+	})
+}
+
+func TestValueEncodingsSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		additional    map[string]interface{}
+		encodings     map[string]string
+		expectedError string
+	}{
+		{
+			"unknown encoding",
+			map[string]interface{}{"example.com/team": "platform"},
+			map[string]string{"example.com/team": "xml"},
+			"value_encodings example.com/team must be one of raw, json, yaml, base64",
+		},
+		{
+			"unknown key",
+			map[string]interface{}{"example.com/team": "platform"},
+			map[string]string{"example.com/owner": EncodingJSON},
+			"value_encodings example.com/owner is not a key of additional_annotations or conditional_annotations",
+		},
+		{
+			"unsupported type",
+			map[string]interface{}{"example.com/team": []string{"platform"}},
+			nil,
+			"additional_annotations example.com/team: unsupported value type []string",
+		},
+		{
+			"unsupported nested type",
+			map[string]interface{}{"example.com/team": map[string]interface{}{"members": struct{}{}}},
+			nil,
+			"additional_annotations example.com/team: unsupported value type struct {}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:                "LOG_PATH",
+				AnnotationBase:        "log_path",
+				AnnotationExtFormat:   "log_path_%d",
+				AdditionalAnnotations: test.additional,
+				ValueEncodings:        test.encodings,
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	expansionOperator        = '$'
+	expansionReferenceOpener = '('
+	expansionReferenceCloser = ')'
+)
+
+// expandEnvReferences expands the $(VAR_NAME) references found in the value of an
+// environment variable the same way the kubelet does:
+//   - references to variables defined in `vars` are replaced by their value;
+//   - references to undefined variables are left untouched;
+//   - `$$` is an escaped `$`, so `$$(VAR_NAME)` produces the literal `$(VAR_NAME)`.
+func expandEnvReferences(input string, vars map[string]string) string {
+	var buf strings.Builder
+	checkpoint := 0
+	for cursor := 0; cursor < len(input); cursor++ {
+		if input[cursor] == expansionOperator && cursor+1 < len(input) {
+			// Copy the portion of the input string since the last checkpoint
+			buf.WriteString(input[checkpoint:cursor])
+
+			// Attempt to read the variable name as defined by the syntax from the input string
+			read, isVar, advance := tryReadVariableName(input[cursor+1:])
+			if isVar {
+				if value, ok := vars[read]; ok {
+					buf.WriteString(value)
+				} else {
+					buf.WriteString("$(" + read + ")")
+				}
+			} else {
+				buf.WriteString(read)
+			}
+
+			// Advance the cursor in the input string to account for the bytes consumed
+			cursor += advance
+			checkpoint = cursor + 1
+		}
+	}
+
+	// Return the buffer and any remaining unwritten bytes in the input string
+	return buf.String() + input[checkpoint:]
+}
+
+// tryReadVariableName attempts to read a variable name from the input string, which
+// starts right after a `$`. It returns the text to write, whether it is a variable name
+// and the number of bytes consumed.
+func tryReadVariableName(input string) (string, bool, int) {
+	switch input[0] {
+	case expansionOperator:
+		// Escaped operator, return it
+		return input[0:1], false, 1
+	case expansionReferenceOpener:
+		// Scan to the end of the reference
+		for i := 1; i < len(input); i++ {
+			if input[i] == expansionReferenceCloser {
+				return input[1:i], true, i + 1
+			}
+		}
+		// Incomplete reference, return the operator and the opener
+		return string(expansionOperator) + string(expansionReferenceOpener), false, 1
+	default:
+		// Not the beginning of a reference, return the operator and the following character
+		return string(expansionOperator) + string(input[0]), false, 1
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+func TestExpandEnvReferences(t *testing.T) {
+	vars := map[string]string{
+		"LOG_DIR": "/var/log/app",
+		"EMPTY":   "",
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"/var/log/app.log", "/var/log/app.log"},
+		{"$(LOG_DIR)/app.log", "/var/log/app/app.log"},
+		{"$(LOG_DIR)/$(LOG_DIR)", "/var/log/app//var/log/app"},
+		{"$(EMPTY)/app.log", "/app.log"},
+		{"$(UNDEFINED)/app.log", "$(UNDEFINED)/app.log"},
+		{"$$(LOG_DIR)/app.log", "$(LOG_DIR)/app.log"},
+		{"$$$(LOG_DIR)/app.log", "$/var/log/app/app.log"},
+		{"$LOG_DIR/app.log", "$LOG_DIR/app.log"},
+		{"$(LOG_DIR/app.log", "$(LOG_DIR/app.log"},
+		{"/var/log/app$", "/var/log/app$"},
+		{"$()", "$()"},
+	}
+
+	for _, test := range tests {
+		if actual := expandEnvReferences(test.input, vars); actual != test.expected {
+			t.Errorf("Expected %q to expand to %q, got %q", test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestCheckEnvVarsExpandsEarlierVariables(t *testing.T) {
+	withEnv := func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].Env = []*corev1.EnvVar{
+			{Name: stringPtr("LOG_PATH"), Value: "$(LOG_DIR)/early.log"},
+			{Name: stringPtr("LOG_DIR"), Value: "/var/log/app"},
+			{Name: stringPtr("LOG_PATH"), Value: "$(LOG_DIR)/app.log"},
+			{Name: stringPtr("LOG_DIR"), Value: "$(LOG_DIR)/nested"},
+			{Name: stringPtr("LOG_PATH"), Value: "$(LOG_DIR)/nested.log"},
+		}
+	}
+
+	paths, rejection := podLogPaths(t, "LOG_PATH", Lookups{}, &memoryResourceLookup{}, withEnv)
+	if rejection != "" {
+		t.Fatalf("Unexpected rejection: %s", rejection)
+	}
+	expected := []string{"$(LOG_DIR)/early.log", "/var/log/app/app.log", "/var/log/app/nested/nested.log"}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %q, got %q", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], paths[i])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ExtKeyIndex numbers the extended annotation keys by position: 1, 2, 3...
+	ExtKeyIndex = "index"
+	// ExtKeyEnvSuffix names the extended annotation keys after the suffix of the environment variable.
+	ExtKeyEnvSuffix = "env_suffix"
+	// ExtKeyPathSlug names the extended annotation keys after a slug of the log path.
+	ExtKeyPathSlug = "path_slug"
+	// ExtKeyHash names the extended annotation keys after a short hash of the log path.
+	ExtKeyHash = "hash"
+	// extKeySlugMaxLength is the maximum length of a slug, longer ones are truncated and hashed.
+	extKeySlugMaxLength = 40
+	// extKeyHashLength is the number of hexadecimal digits of the hashes used in the extended keys.
+	extKeyHashLength = 8
+)
+
+// validExtKeyMode validates the extended key mode along with the settings it depends on.
+func (s *Settings) validExtKeyMode() error {
+	switch s.ExtKeyMode {
+	case "", ExtKeyIndex:
+		if s.BaseEnv != "" {
+			return fmt.Errorf("base_env requires ext_key_mode %s, %s or %s", ExtKeyEnvSuffix, ExtKeyPathSlug, ExtKeyHash)
+		}
+		return nil
+	case ExtKeyEnvSuffix, ExtKeyPathSlug, ExtKeyHash:
+	default:
+		return fmt.Errorf("ext_key_mode must be one of %s, %s, %s, %s",
+			ExtKeyIndex, ExtKeyEnvSuffix, ExtKeyPathSlug, ExtKeyHash)
+	}
+
+	if s.TemplateMode == TemplateModeGoTemplate {
+		return fmt.Errorf("ext_key_mode %s requires template_mode %s", s.ExtKeyMode, TemplateModeFormat)
+	}
+	if s.numberedOutput() && !strings.Contains(s.AnnotationExtFormat, "%s") {
+		return fmt.Errorf("annotation_ext_format must contain %%s placeholder when ext_key_mode is %s", s.ExtKeyMode)
+	}
+	return nil
+}
+
+// stableExtKeys checks if the extended annotation keys derive from the log paths rather than
+// from their position.
+func (s *Settings) stableExtKeys() bool {
+	return s.ExtKeyMode != "" && s.ExtKeyMode != ExtKeyIndex
+}
+
+// basePath checks if a log path goes to annotation_base when the extended keys are stable: it has to be
+// declared by base_env, or by an environment variable matching env_key without a suffix.
+func (s *Settings) basePath(path logPath) bool {
+	switch {
+	case s.BaseEnv == "":
+		return path.Captures[SuffixCaptureGroup] == ""
+	case s.EnvKeyCaseInsensitive:
+		return strings.EqualFold(path.Env, s.BaseEnv)
+	default:
+		return path.Env == s.BaseEnv
+	}
+}
+
+// addStableAnnotations assigns each log path to its base or stable extended annotation key. The base key
+// goes to the first log path selected by basePath rather than to the first one, so that reordering the
+// environment variables moves no log path between annotation_base and an extended key. Without such a
+// log path, the base key is not set.
+func addStableAnnotations(
+	annotations map[string]string,
+	logPaths []logPath,
+	renderer *annotationRenderer,
+	info requestInfo,
+) error {
+	baseKeys := make([]string, len(logPaths))
+	bases := make(map[string]int)
+	for index, path := range logPaths {
+		baseKey, err := renderer.baseKey(path, pathData(info, path, index))
+		if err != nil {
+			return err
+		}
+		baseKeys[index] = baseKey
+		if _, found := bases[baseKey]; !found && renderer.settings.basePath(path) {
+			bases[baseKey] = index
+		}
+	}
+
+	for index, path := range logPaths {
+		if base, found := bases[baseKeys[index]]; found && base == index {
+			annotations[baseKeys[index]] = path.Path
+			continue
+		}
+		if extKey, ok := renderer.stableExtKey(path, annotations); ok {
+			annotations[extKey] = path.Path
+		}
+	}
+	return nil
+}
+
+// extKeyID returns the stable identifier of a log path used in its extended annotation key.
+func extKeyID(path logPath, mode string) string {
+	switch mode {
+	case ExtKeyEnvSuffix:
+		if suffix, ok := path.Captures[SuffixCaptureGroup]; ok && suffix != "" {
+			return slugify(suffix)
+		}
+		return slugify(path.Env)
+	case ExtKeyPathSlug:
+		return slugify(path.Path)
+	default:
+		return shortHash(path.Path)
+	}
+}
+
+//nolint:gochecknoglobals // Read-only pattern shared by all the slugs.
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a text into a lower case identifier made of alphanumeric characters separated by
+// dashes. Long slugs are truncated and suffixed with a hash of the text, empty ones replaced by it.
+func slugify(text string) string {
+	slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(text), "-"), "-")
+	switch {
+	case slug == "":
+		return shortHash(text)
+	case len(slug) > extKeySlugMaxLength:
+		truncated := strings.TrimRight(slug[:extKeySlugMaxLength-extKeyHashLength-1], "-")
+		return truncated + "-" + shortHash(text)
+	default:
+		return slug
+	}
+}
+
+// shortHash returns the first hexadecimal digits of the sha256 hash of a text.
+func shortHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])[:extKeyHashLength]
+}
+
+// stableExtKey returns the extended annotation key of a log path derived from its stable identifier.
+// When the key is already taken by another log path, the identifier is suffixed with -2, -3...
+// The boolean is false when no extended key is configured.
+func (r *annotationRenderer) stableExtKey(path logPath, taken map[string]string) (string, bool) {
+	_, extFormat := annotationKeys(path, r.settings)
+	if extFormat == "" {
+		return "", false
+	}
+
+	id := extKeyID(path, r.settings.ExtKeyMode)
+	key := fmt.Sprintf(extFormat, id)
+	for n := 2; ; n++ {
+		if _, found := taken[key]; !found {
+			return key, true
+		}
+		key = fmt.Sprintf(extFormat, fmt.Sprintf("%s-%d", id, n))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+func TestStableExtKeys(t *testing.T) {
+	mainLog := &corev1.EnvVar{Name: stringPtr("LOG_PATH_MAIN"), Value: "/var/log/main.log"}
+	debug := &corev1.EnvVar{Name: stringPtr("LOG_PATH_DEBUG"), Value: "/var/log/debug.log"}
+	audit := &corev1.EnvVar{Name: stringPtr("LOG_PATH_AUDIT"), Value: "/var/log/audit.log"}
+
+	tests := []struct {
+		mode                string
+		expectedAnnotations map[string]string
+	}{
+		{
+			ExtKeyEnvSuffix,
+			map[string]string{
+				"logs_path":           "/var/log/main.log",
+				"logs_path_ext_debug": "/var/log/debug.log",
+				"logs_path_ext_audit": "/var/log/audit.log",
+			},
+		},
+		{
+			ExtKeyPathSlug,
+			map[string]string{
+				"logs_path":                       "/var/log/main.log",
+				"logs_path_ext_var-log-debug-log": "/var/log/debug.log",
+				"logs_path_ext_var-log-audit-log": "/var/log/audit.log",
+			},
+		},
+		{
+			ExtKeyHash,
+			map[string]string{
+				"logs_path": "/var/log/main.log",
+				"logs_path_ext_" + shortHash("/var/log/debug.log"): "/var/log/debug.log",
+				"logs_path_ext_" + shortHash("/var/log/audit.log"): "/var/log/audit.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH_",
+				EnvKeyMatch:         EnvKeyMatchPrefix,
+				AnnotationBase:      "logs_path",
+				AnnotationExtFormat: "logs_path_ext_%s",
+				ExtKeyMode:          test.mode,
+				BaseEnv:             "LOG_PATH_MAIN",
+			}
+			if valid, err := settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid, got: %v", err)
+			}
+
+			// Inserting an environment variable at the top does not change the keys of the other log paths
+			response, err := validateTest(t, podRequest(settings, nil, mainLog, audit))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := map[string]string{}
+			for key, value := range test.expectedAnnotations {
+				if value != "/var/log/debug.log" {
+					expected[key] = value
+				}
+			}
+			assertMutation(t, response, expected)
+
+			response, err = validateTest(t, podRequest(settings, nil, debug, mainLog, audit))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assertMutation(t, response, test.expectedAnnotations)
+		})
+	}
+}
+
+func TestStableBaseKey(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		EnvKeyMatch:         EnvKeyMatchPrefix,
+		AnnotationBase:      "logs_path",
+		AnnotationExtFormat: "logs_path_ext_%s",
+		ExtKeyMode:          ExtKeyEnvSuffix,
+	}
+	base := &corev1.EnvVar{Name: stringPtr("LOG_PATH"), Value: "/var/log/app.log"}
+	access := &corev1.EnvVar{Name: stringPtr("LOG_PATH_ACCESS"), Value: "/var/log/access.log"}
+	audit := &corev1.EnvVar{Name: stringPtr("LOG_PATH_AUDIT"), Value: "/var/log/audit.log"}
+
+	tests := []struct {
+		name                string
+		env                 []*corev1.EnvVar
+		expectedAnnotations map[string]string
+	}{
+		{
+			"variable without suffix",
+			[]*corev1.EnvVar{access, base},
+			map[string]string{
+				"logs_path":            "/var/log/app.log",
+				"logs_path_ext_access": "/var/log/access.log",
+			},
+		},
+		{
+			"inserted at the top",
+			[]*corev1.EnvVar{audit, access, base},
+			map[string]string{
+				"logs_path":            "/var/log/app.log",
+				"logs_path_ext_access": "/var/log/access.log",
+				"logs_path_ext_audit":  "/var/log/audit.log",
+			},
+		},
+		{
+			"no variable without suffix",
+			[]*corev1.EnvVar{audit, access},
+			map[string]string{
+				"logs_path_ext_access": "/var/log/access.log",
+				"logs_path_ext_audit":  "/var/log/audit.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := validateTest(t, podRequest(settings, nil, test.env...))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assertMutation(t, response, test.expectedAnnotations)
+		})
+	}
+}
+
+func TestStableExtKeysCollisions(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "logs_path",
+		AnnotationExtFormat: "logs_path_ext_%s",
+		ExtKeyMode:          ExtKeyEnvSuffix,
+	}
+	response, err := validateTest(t, podRequest(settings, nil,
+		&corev1.EnvVar{Name: stringPtr("LOG_PATH"), Value: "/var/log/app.log,/var/log/err.log,/var/log/gc.log"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertMutation(t, response, map[string]string{
+		"logs_path":                "/var/log/app.log",
+		"logs_path_ext_log-path":   "/var/log/err.log",
+		"logs_path_ext_log-path-2": "/var/log/gc.log",
+	})
+}
+
+func TestSlugify(t *testing.T) {
+	long := "/var/log/applications/payments-gateway/production/requests.log"
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"/var/log/app.log", "var-log-app-log"},
+		{"Service_App", "service-app"},
+		{"/", shortHash("/")},
+		{long, "var-log-applications-payments-g-" + shortHash(long)},
+	}
+
+	for _, test := range tests {
+		if slug := slugify(test.text); slug != test.expected {
+			t.Errorf("Expected slug of %s to be %s, got %s", test.text, test.expected, slug)
+		}
+	}
+}
+
+func TestExtKeyModeSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      Settings
+		expectedError string
+	}{
+		{
+			"unknown mode",
+			Settings{AnnotationExtFormat: "log_path_%s", ExtKeyMode: "uuid"},
+			"ext_key_mode must be one of index, env_suffix, path_slug, hash",
+		},
+		{
+			"missing %s placeholder",
+			Settings{AnnotationExtFormat: "log_path_%d", ExtKeyMode: ExtKeyHash},
+			"annotation_ext_format must contain %s placeholder when ext_key_mode is hash",
+		},
+		{
+			"go template",
+			Settings{
+				AnnotationExtFormat: "log_path_{{ .Seq }}",
+				ExtKeyMode:          ExtKeyPathSlug,
+				TemplateMode:        TemplateModeGoTemplate,
+			},
+			"ext_key_mode path_slug requires template_mode format",
+		},
+		{
+			"base_env with index mode",
+			Settings{AnnotationExtFormat: "log_path_%d", BaseEnv: "LOG_PATH"},
+			"base_env requires ext_key_mode env_suffix, path_slug or hash",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := test.settings
+			settings.EnvKey = "LOG_PATH"
+			settings.AnnotationBase = "log_path"
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// TargetAnnotation writes an output to the annotations of the object.
+	TargetAnnotation = "annotation"
+	// TargetLabel writes an output to the labels of the object.
+	TargetLabel = "label"
+	// TargetBoth writes an output to both the annotations and the labels of the object.
+	TargetBoth = "both"
+	// labelValueMaxLength is the maximum length of a label value.
+	labelValueMaxLength = 63
+	// labelValueHashLength is the number of hexadecimal digits of the hash appended to truncated label values.
+	labelValueHashLength = 8
+)
+
+// Targets defines where each output of the policy is written: annotation (default), label or both.
+type Targets struct {
+	// LogPaths is the target of the annotation_base and annotation_ext_format outputs.
+	LogPaths string `json:"log_paths,omitempty"`
+	// ListAnnotation is the target of the list_annotation output.
+	ListAnnotation string `json:"list_annotation,omitempty"`
+	// AdditionalAnnotations is the target of the additional_annotations.
+	AdditionalAnnotations string `json:"additional_annotations,omitempty"`
+	// NoMatch is the target of the no_match fallback annotations.
+	NoMatch string `json:"no_match,omitempty"`
+}
+
+// Valid validates the targets.
+func (t *Targets) Valid() error {
+	targets := []struct {
+		name   string
+		target string
+	}{
+		{"log_paths", t.LogPaths},
+		{"list_annotation", t.ListAnnotation},
+		{"additional_annotations", t.AdditionalAnnotations},
+		{"no_match", t.NoMatch},
+	}
+	for _, target := range targets {
+		switch target.target {
+		case "", TargetAnnotation, TargetLabel, TargetBoth:
+		default:
+			return fmt.Errorf("targets %s must be one of %s, %s, %s",
+				target.name, TargetAnnotation, TargetLabel, TargetBoth)
+		}
+	}
+	return nil
+}
+
+// addToTargets adds the entries of an output to the annotations, to the labels or to both,
+// according to the target. The label values are sanitized.
+func addToTargets(annotations, labels, entries map[string]string, target string) {
+	for key, value := range entries {
+		if target != TargetLabel {
+			annotations[key] = value
+		}
+		if target == TargetLabel || target == TargetBoth {
+			labels[key] = sanitizeLabelValue(value)
+		}
+	}
+}
+
+//nolint:gochecknoglobals // Read-only pattern applied to every label value.
+var invalidLabelCharacters = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// sanitizeLabelValue turns a value into a valid label value: the characters other than alphanumeric
+// characters, '-', '_' and '.' are replaced by '_', the value is trimmed so that it starts and ends
+// with an alphanumeric character, and values longer than 63 characters are truncated and suffixed
+// with a hash of the original value, so that different values remain distinct.
+func sanitizeLabelValue(value string) string {
+	isAlphanumeric := func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+	}
+	trimNonAlphanumeric := func(s string) string {
+		return strings.TrimFunc(s, func(r rune) bool { return !isAlphanumeric(r) })
+	}
+
+	sanitized := trimNonAlphanumeric(invalidLabelCharacters.ReplaceAllString(value, "_"))
+	if len(sanitized) <= labelValueMaxLength {
+		return sanitized
+	}
+
+	sum := sha256.Sum256([]byte(value))
+	hash := hex.EncodeToString(sum[:])[:labelValueHashLength]
+	truncated := trimNonAlphanumeric(sanitized[:labelValueMaxLength-labelValueHashLength-1])
+	return truncated + "-" + hash
+}
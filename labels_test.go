@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeLabelValue(t *testing.T) {
+	long := "/var/log/" + strings.Repeat("application/", 6) + "app.log"
+
+	tests := []struct {
+		value         string
+		expectedValue string
+	}{
+		{"platform", "platform"},
+		{"true", "true"},
+		{"/var/log/app.log", "var_log_app.log"},
+		{"/var/log/app.log,/var/log/err.log", "var_log_app.log__var_log_err.log"},
+		{"-app-", "app"},
+		{"///", ""},
+		{long, "var_log_application_application_application_applicatio-71d65c55"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			sanitized := sanitizeLabelValue(test.value)
+			if sanitized != test.expectedValue {
+				t.Errorf("Expected %s, got %s", test.expectedValue, sanitized)
+			}
+			if len(sanitized) > labelValueMaxLength {
+				t.Errorf("Expected at most %d characters, got %d", labelValueMaxLength, len(sanitized))
+			}
+		})
+	}
+
+	if sanitizeLabelValue(long) == sanitizeLabelValue(long+"2") {
+		t.Error("Expected truncated values to remain distinct")
+	}
+}
+
+func TestTargets(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		AdditionalAnnotations: map[string]interface{}{
+			"logging.example.com/flow": "app-logs",
+		},
+		Targets: Targets{LogPaths: TargetBoth, AdditionalAnnotations: TargetLabel},
+	}
+
+	response := validateFixture(t, "test_data/pod-multiple-containers.json", settings)
+	assertMutation(t, response, map[string]string{
+		"co_elastic_logs_path":       "/var/log/app.log",
+		"co_elastic_logs_path_ext_1": "/var/log/worker.log",
+		"co_elastic_logs_path_ext_2": "/var/log/worker-err.log",
+	})
+
+	labels := mutatedMap(t, response, "metadata", "labels")
+	expectedLabels := map[string]string{
+		"co_elastic_logs_path":       "var_log_app.log",
+		"co_elastic_logs_path_ext_1": "var_log_worker.log",
+		"co_elastic_logs_path_ext_2": "var_log_worker-err.log",
+		"logging.example.com/flow":   "app-logs",
+	}
+	if len(labels) != len(expectedLabels) {
+		t.Errorf("Expected labels %v, got %v", expectedLabels, labels)
+	}
+	for key, expectedValue := range expectedLabels {
+		if labels[key] != expectedValue {
+			t.Errorf("Expected label %s to be %s, got %v", key, expectedValue, labels[key])
+		}
+	}
+}
+
+func TestWorkloadLabelTargets(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		Targets:             Targets{LogPaths: TargetLabel},
+	}
+
+	response := validateFixture(t, "test_data/deployment-env.json", settings)
+	labels := mutatedMap(t, response, "spec", "template", "metadata", "labels")
+	if labels["co_elastic_logs_path"] != "var_log_app.log" || labels["co_elastic_logs_path_ext_1"] != "var_log_err.log" {
+		t.Errorf("Unexpected pod template labels: %v", labels)
+	}
+	annotations, _ := lookupMap(mutatedMap(t, response, "spec", "template", "metadata"), []string{"annotations"})
+	if _, found := annotations["co_elastic_logs_path"]; found {
+		t.Errorf("Expected log paths not to be written to the annotations, got %v", annotations)
+	}
+}
+
+func TestTargetsSettings(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "log_path",
+		AnnotationExtFormat: "log_path_%d",
+		Targets:             Targets{NoMatch: "labels"},
+	}
+
+	valid, err := settings.Valid()
+	if valid {
+		t.Errorf("Expected settings to be invalid")
+	}
+	expectedError := "targets no_match must be one of annotation, label, both"
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities"
+	"github.com/kubewarden/policy-sdk-go/pkg/capabilities/kubernetes"
+)
+
+const (
+	// ConfigMapKind is the kind of the ConfigMap resources.
+	ConfigMapKind = "ConfigMap"
+	// SecretKind is the kind of the Secret resources.
+	SecretKind = "Secret"
+	// OnMissingSkip ignores the environment variables whose referenced value cannot be found.
+	OnMissingSkip = "skip"
+	// OnMissingFallback uses the configured fallback value instead of the missing one.
+	OnMissingFallback = "fallback"
+	// OnMissingReject rejects the request when a referenced value cannot be found.
+	OnMissingReject = "reject"
+)
+
+// ErrResourceNotFound is returned by the ResourceLookup implementations when the resource does not exist.
+var ErrResourceNotFound = errors.New("resource not found")
+
+// Lookups configures the context-aware resolution of the values defined in other resources.
+type Lookups struct {
+	// ConfigMaps enables the resolution of valueFrom.configMapKeyRef and envFrom.configMapRef references.
+	ConfigMaps bool `json:"config_maps,omitempty"`
+	// Secrets enables the resolution of valueFrom.secretKeyRef and envFrom.secretRef references.
+	Secrets bool `json:"secrets,omitempty"`
+	// OnMissing defines what happens when the referenced resource or key does not exist:
+	// skip (default), fallback or reject. References marked as optional are always skipped,
+	// missing envFrom sources are never replaced by the fallback value. The other lookup
+	// failures, such as denied accesses, always reject the request.
+	OnMissing string `json:"on_missing,omitempty"`
+	// FallbackValue is the value used when OnMissing is fallback.
+	FallbackValue string `json:"fallback_value,omitempty"`
+}
+
+// Valid validates the lookups settings.
+func (l *Lookups) Valid() error {
+	switch l.OnMissing {
+	case "", OnMissingSkip, OnMissingReject:
+	case OnMissingFallback:
+		if l.FallbackValue == "" {
+			return errors.New("lookups fallback_value cannot be empty when on_missing is " + OnMissingFallback)
+		}
+	default:
+		return fmt.Errorf("lookups on_missing must be one of %s, %s, %s",
+			OnMissingSkip, OnMissingFallback, OnMissingReject)
+	}
+	return nil
+}
+
+// ResourceLookup fetches the Kubernetes resources referenced by the containers of a Pod.
+// Missing resources are reported with an error wrapping ErrResourceNotFound.
+type ResourceLookup interface {
+	// ConfigMapData returns the data of the ConfigMap with the given namespace and name.
+	ConfigMapData(namespace, name string) (map[string]string, error)
+	// SecretData returns the decoded data of the Secret with the given namespace and name.
+	SecretData(namespace, name string) (map[string]string, error)
+}
+
+// hostResourceLookup fetches Kubernetes resources through the Kubewarden host capabilities.
+type hostResourceLookup struct {
+	host capabilities.Host
+}
+
+// newHostResourceLookup creates a ResourceLookup backed by the Kubewarden host.
+func newHostResourceLookup() *hostResourceLookup {
+	return &hostResourceLookup{host: capabilities.NewHost()}
+}
+
+// getResource fetches a namespaced core resource and decodes it into obj.
+func (l *hostResourceLookup) getResource(kind, namespace, name string, obj interface{}) error {
+	response, err := kubernetes.GetResource(&l.host, kubernetes.GetResourceRequest{
+		APIVersion: "v1",
+		Kind:       kind,
+		Name:       name,
+		Namespace:  &namespace,
+	})
+	if err != nil {
+		if isNotFoundError(err) {
+			return fmt.Errorf("%s %s/%s: %w", kind, namespace, name, ErrResourceNotFound)
+		}
+		return err
+	}
+
+	if err = json.Unmarshal(response, obj); err != nil {
+		return fmt.Errorf("cannot decode %s %s/%s: %w", kind, namespace, name, err)
+	}
+	return nil
+}
+
+// isNotFoundError checks if an error of the host reports a missing resource, as opposed to
+// a denied access or a failure of the host.
+func isNotFoundError(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "NotFound") || strings.Contains(message, "not found")
+}
+
+// ConfigMapData returns the data of the ConfigMap with the given namespace and name.
+func (l *hostResourceLookup) ConfigMapData(namespace, name string) (map[string]string, error) {
+	var configMap corev1.ConfigMap
+	if err := l.getResource(ConfigMapKind, namespace, name, &configMap); err != nil {
+		return nil, err
+	}
+	return configMap.Data, nil
+}
+
+// SecretData returns the decoded data of the Secret with the given namespace and name.
+func (l *hostResourceLookup) SecretData(namespace, name string) (map[string]string, error) {
+	var secret corev1.Secret
+	if err := l.getResource(SecretKind, namespace, name, &secret); err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		data[key] = string(value)
+	}
+	return data, nil
+}
+
+// valueResolver resolves the values of environment variables, looking up the resources
+// referenced through valueFrom and envFrom when enabled. A nil resolver only uses plain values.
+type valueResolver struct {
+	lookup    ResourceLookup
+	namespace string
+	settings  Lookups
+	resources map[string]map[string]string
+}
+
+// newValueResolver creates a resolver for the containers of a request in the given namespace.
+func newValueResolver(lookup ResourceLookup, namespace string, settings Lookups) *valueResolver {
+	return &valueResolver{
+		lookup:    lookup,
+		namespace: namespace,
+		settings:  settings,
+		resources: make(map[string]map[string]string),
+	}
+}
+
+// resourceData returns the data of a ConfigMap or Secret of the request namespace, caching the result.
+// Missing resources are returned as nil data, the other lookup failures as an error.
+func (r *valueResolver) resourceData(kind, name string) (map[string]string, error) {
+	cacheKey := kind + "/" + name
+	if data, found := r.resources[cacheKey]; found {
+		return data, nil
+	}
+
+	var data map[string]string
+	var err error
+	if kind == SecretKind {
+		data, err = r.lookup.SecretData(r.namespace, name)
+	} else {
+		data, err = r.lookup.ConfigMapData(r.namespace, name)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrResourceNotFound) {
+			return nil, fmt.Errorf("cannot look up %s %s/%s: %w", kind, r.namespace, name, err)
+		}
+		logger.WarnWith(kind+" not found").
+			String("namespace", r.namespace).
+			String("name", name).
+			Write()
+		data = nil
+	}
+	r.resources[cacheKey] = data
+	return data, nil
+}
+
+// resolve returns the value of an environment variable. The boolean is false when the
+// variable has no value to use.
+func (r *valueResolver) resolve(env *corev1.EnvVar) (string, bool, error) {
+	if env.ValueFrom == nil {
+		return env.Value, true, nil
+	}
+	if r == nil {
+		return "", false, nil
+	}
+
+	var kind, name string
+	var key *string
+	var optional bool
+	switch {
+	case r.settings.ConfigMaps && env.ValueFrom.ConfigMapKeyRef != nil:
+		kind, name = ConfigMapKind, env.ValueFrom.ConfigMapKeyRef.Name
+		key, optional = env.ValueFrom.ConfigMapKeyRef.Key, env.ValueFrom.ConfigMapKeyRef.Optional
+	case r.settings.Secrets && env.ValueFrom.SecretKeyRef != nil:
+		kind, name = SecretKind, env.ValueFrom.SecretKeyRef.Name
+		key, optional = env.ValueFrom.SecretKeyRef.Key, env.ValueFrom.SecretKeyRef.Optional
+	default:
+		return "", false, nil
+	}
+	if key == nil {
+		return "", false, nil
+	}
+
+	data, err := r.resourceData(kind, name)
+	if err != nil {
+		return "", false, err
+	}
+	if value, found := data[*key]; found {
+		return value, true, nil
+	}
+	if optional {
+		return "", false, nil
+	}
+
+	switch r.settings.OnMissing {
+	case OnMissingFallback:
+		return r.settings.FallbackValue, true, nil
+	case OnMissingReject:
+		return "", false, fmt.Errorf("key %s of %s %s/%s referenced by environment variable %s not found",
+			*key, kind, r.namespace, name, *env.Name)
+	default:
+		return "", false, nil
+	}
+}
+
+// envFromValues returns the environment variables defined by the envFrom sources of a container,
+// with their prefix applied. Later sources take precedence over earlier ones, like in the kubelet.
+func (r *valueResolver) envFromValues(container *corev1.Container) (map[string]string, error) {
+	values := make(map[string]string)
+	if r == nil {
+		return values, nil
+	}
+
+	for _, source := range container.EnvFrom {
+		if source == nil {
+			continue
+		}
+
+		var kind, name string
+		var optional bool
+		switch {
+		case r.settings.ConfigMaps && source.ConfigMapRef != nil:
+			kind, name, optional = ConfigMapKind, source.ConfigMapRef.Name, source.ConfigMapRef.Optional
+		case r.settings.Secrets && source.SecretRef != nil:
+			kind, name, optional = SecretKind, source.SecretRef.Name, source.SecretRef.Optional
+		default:
+			continue
+		}
+
+		data, err := r.resourceData(kind, name)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil && !optional && r.settings.OnMissing == OnMissingReject {
+			return nil, fmt.Errorf("%s %s/%s referenced by envFrom not found", kind, r.namespace, name)
+		}
+		for key, value := range data {
+			values[source.Prefix+key] = value
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+// memoryResourceLookup is an in-memory ResourceLookup, resources are indexed by namespace/name.
+type memoryResourceLookup struct {
+	configMaps map[string]map[string]string
+	secrets    map[string]map[string]string
+	calls      int
+	// err is returned by every lookup when set, like a denied access.
+	err error
+}
+
+func (l *memoryResourceLookup) ConfigMapData(namespace, name string) (map[string]string, error) {
+	l.calls++
+	if l.err != nil {
+		return nil, l.err
+	}
+	data, found := l.configMaps[namespace+"/"+name]
+	if !found {
+		return nil, fmt.Errorf("configmaps %q: %w", name, ErrResourceNotFound)
+	}
+	return data, nil
+}
+
+func (l *memoryResourceLookup) SecretData(namespace, name string) (map[string]string, error) {
+	l.calls++
+	if l.err != nil {
+		return nil, l.err
+	}
+	data, found := l.secrets[namespace+"/"+name]
+	if !found {
+		return nil, fmt.Errorf("secrets %q: %w", name, ErrResourceNotFound)
+	}
+	return data, nil
+}
+
+// validateWithLookupTest executes the policy validation using the given lookup.
+func validateWithLookupTest(
+	t *testing.T,
+	request kubewarden_protocol.ValidationRequest,
+	lookup ResourceLookup,
+) *kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	responsePayload, err := validateWithLookup(mustMarshalJSON(request), lookup)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var response kubewarden_protocol.ValidationResponse
+	if err = json.Unmarshal(responsePayload, &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return &response
+}
+
+// configMapKeyRefEnv returns the environment variables of a container reading LOG_PATH from the given
+// ConfigMap key, followed by a plain LOG_PATH.
+func configMapKeyRefEnv(configMapName, key string, optional bool) []*corev1.EnvVar {
+	return []*corev1.EnvVar{
+		{
+			Name: stringPtr("LOG_PATH"),
+			ValueFrom: &corev1.EnvVarSource{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					Name:     configMapName,
+					Key:      stringPtr(key),
+					Optional: optional,
+				},
+			},
+		},
+		{
+			Name:  stringPtr("LOG_PATH"),
+			Value: "/var/log/plain.log",
+		},
+	}
+}
+
+func TestLookupFailuresAreRejected(t *testing.T) {
+	lookup := &memoryResourceLookup{err: errors.New(`configmaps "logging" is forbidden`)}
+	expectedRejection := "cannot look up ConfigMap default/logging: configmaps \"logging\" is forbidden"
+
+	// Only missing resources are skipped or replaced by the fallback value
+	for _, onMissing := range []string{OnMissingSkip, OnMissingFallback, OnMissingReject} {
+		t.Run(onMissing, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "co_elastic_logs_path",
+				AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+				Lookups:             Lookups{ConfigMaps: true, OnMissing: onMissing, FallbackValue: "/var/log/fallback.log"},
+			}
+
+			request := podRequest(settings, nil, configMapKeyRefEnv("logging", "path", false)...)
+			response := validateWithLookupTest(t, request, lookup)
+			assertRejection(t, response, expectedRejection)
+		})
+	}
+}
+
+func TestIsNotFoundError(t *testing.T) {
+	tests := []struct {
+		message  string
+		expected bool
+	}{
+		{`ApiError: configmaps "logging" not found: NotFound`, true},
+		{`ApiError: configmaps "logging" is forbidden: Forbidden`, false},
+		{"host call timed out", false},
+	}
+
+	for _, test := range tests {
+		if found := isNotFoundError(errors.New(test.message)); found != test.expected {
+			t.Errorf("Expected %q to be a not found error: %t", test.message, test.expected)
+		}
+	}
+}
+
+func TestConfigMapKeyRefLookups(t *testing.T) {
+	lookup := &memoryResourceLookup{
+		configMaps: map[string]map[string]string{
+			"default/logging": {"path": "/var/log/from-configmap.log"},
+		},
+	}
+
+	tests := []struct {
+		name                string
+		lookups             Lookups
+		configMap           string
+		key                 string
+		optional            bool
+		expectedAnnotations map[string]string
+		expectedRejection   string
+	}{
+		{
+			name:      "lookups disabled",
+			configMap: "logging",
+			key:       "path",
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path": "/var/log/plain.log",
+			},
+		},
+		{
+			name:      "value found",
+			lookups:   Lookups{ConfigMaps: true},
+			configMap: "logging",
+			key:       "path",
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/from-configmap.log",
+				"co_elastic_logs_path_ext_1": "/var/log/plain.log",
+			},
+		},
+		{
+			name:      "missing key skipped",
+			lookups:   Lookups{ConfigMaps: true, OnMissing: OnMissingSkip},
+			configMap: "logging",
+			key:       "other",
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path": "/var/log/plain.log",
+			},
+		},
+		{
+			name:      "missing configmap replaced by fallback",
+			lookups:   Lookups{ConfigMaps: true, OnMissing: OnMissingFallback, FallbackValue: "/var/log/fallback.log"},
+			configMap: "missing",
+			key:       "path",
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/fallback.log",
+				"co_elastic_logs_path_ext_1": "/var/log/plain.log",
+			},
+		},
+		{
+			name:      "optional reference skipped despite reject",
+			lookups:   Lookups{ConfigMaps: true, OnMissing: OnMissingReject},
+			configMap: "missing",
+			key:       "path",
+			optional:  true,
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path": "/var/log/plain.log",
+			},
+		},
+		{
+			name:      "missing configmap rejected",
+			lookups:   Lookups{ConfigMaps: true, OnMissing: OnMissingReject},
+			configMap: "missing",
+			key:       "path",
+			expectedRejection: "key path of ConfigMap default/missing referenced by environment variable " +
+				"LOG_PATH not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "co_elastic_logs_path",
+				AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+				Lookups:             test.lookups,
+			}
+
+			request := podRequest(settings, nil, configMapKeyRefEnv(test.configMap, test.key, test.optional)...)
+			response := validateWithLookupTest(t, request, lookup)
+
+			if test.expectedRejection != "" {
+				if response.Accepted {
+					t.Fatal("Expected request to be rejected")
+				}
+				if response.Message == nil || *response.Message != test.expectedRejection {
+					t.Errorf("Expected rejection message '%s', got %v", test.expectedRejection, response.Message)
+				}
+				return
+			}
+			assertMutation(t, response, test.expectedAnnotations)
+		})
+	}
+}
+
+func TestConfigMapLookupsAreCached(t *testing.T) {
+	lookup := &memoryResourceLookup{
+		configMaps: map[string]map[string]string{
+			"default/logging": {"path": "/var/log/app.log"},
+		},
+	}
+	resolver := newValueResolver(lookup, "default", Lookups{ConfigMaps: true})
+	env := &corev1.EnvVar{
+		Name: stringPtr("LOG_PATH"),
+		ValueFrom: &corev1.EnvVarSource{
+			ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Name: "logging", Key: stringPtr("path")},
+		},
+	}
+
+	for range 3 {
+		if value, found, err := resolver.resolve(env); err != nil || !found || value != "/var/log/app.log" {
+			t.Errorf("Unexpected resolution: %q, %v, %v", value, found, err)
+		}
+	}
+	if lookup.calls != 1 {
+		t.Errorf("Expected the ConfigMap to be looked up once, got %d calls", lookup.calls)
+	}
+}
+
+func TestEnvFromSources(t *testing.T) {
+	lookup := &memoryResourceLookup{
+		configMaps: map[string]map[string]string{
+			"default/logging":  {"LOG_PATH": "/var/log/configmap.log", "LOG_DIR": "/var/log/dir"},
+			"default/override": {"LOG_PATH": "/var/log/override.log"},
+		},
+		secrets: map[string]map[string]string{
+			"default/logging": {"LOG_PATH": "/var/log/secret.log"},
+		},
+	}
+	configMapRef := func(name, prefix string) *corev1.EnvFromSource {
+		return &corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{Name: name}, Prefix: prefix}
+	}
+	secretRef := &corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{Name: "logging"}}
+
+	tests := []struct {
+		name     string
+		lookups  Lookups
+		envKey   string
+		envFrom  []*corev1.EnvFromSource
+		env      []*corev1.EnvVar
+		expected []string
+	}{
+		{
+			name:     "lookups disabled",
+			envKey:   "LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{configMapRef("logging", "")},
+			expected: []string{},
+		},
+		{
+			name:     "configmap source",
+			lookups:  Lookups{ConfigMaps: true},
+			envKey:   "LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{configMapRef("logging", "")},
+			expected: []string{"/var/log/configmap.log"},
+		},
+		{
+			name:     "prefixed configmap source",
+			lookups:  Lookups{ConfigMaps: true},
+			envKey:   "APP_LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{configMapRef("logging", "APP_")},
+			expected: []string{"/var/log/configmap.log"},
+		},
+		{
+			name:     "later source takes precedence",
+			lookups:  Lookups{ConfigMaps: true},
+			envKey:   "LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{configMapRef("logging", ""), configMapRef("override", "")},
+			expected: []string{"/var/log/override.log"},
+		},
+		{
+			name:     "env takes precedence over envFrom",
+			lookups:  Lookups{ConfigMaps: true},
+			envKey:   "LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{configMapRef("logging", "")},
+			env:      []*corev1.EnvVar{{Name: stringPtr("LOG_PATH"), Value: "$(LOG_DIR)/app.log"}},
+			expected: []string{"/var/log/dir/app.log"},
+		},
+		{
+			name:     "secrets are opt-in",
+			lookups:  Lookups{ConfigMaps: true},
+			envKey:   "LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{secretRef},
+			expected: []string{},
+		},
+		{
+			name:     "secret source",
+			lookups:  Lookups{Secrets: true},
+			envKey:   "LOG_PATH",
+			envFrom:  []*corev1.EnvFromSource{configMapRef("logging", ""), secretRef},
+			expected: []string{"/var/log/secret.log"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withSources := func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].EnvFrom = test.envFrom
+				pod.Spec.Containers[0].Env = test.env
+			}
+
+			paths, rejection := podLogPaths(t, test.envKey, test.lookups, lookup, withSources)
+			if rejection != "" {
+				t.Fatalf("Unexpected rejection: %s", rejection)
+			}
+			if len(paths) != len(test.expected) {
+				t.Fatalf("Expected %q, got %q", test.expected, paths)
+			}
+			for i := range test.expected {
+				if paths[i] != test.expected[i] {
+					t.Errorf("Expected %q, got %q", test.expected[i], paths[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMissingEnvFromSourceRejected(t *testing.T) {
+	lookup := &memoryResourceLookup{}
+	withSources := func(pod *corev1.Pod) {
+		pod.Spec.Containers[0].EnvFrom = []*corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{Name: "optional", Optional: true}},
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{Name: "missing"}},
+		}
+	}
+
+	lookups := Lookups{ConfigMaps: true, OnMissing: OnMissingReject}
+	_, rejection := podLogPaths(t, "LOG_PATH", lookups, lookup, withSources)
+	if rejection != "ConfigMap default/missing referenced by envFrom not found" {
+		t.Errorf("Expected missing envFrom source rejection, got: %q", rejection)
+	}
+
+	if _, rejection = podLogPaths(t, "LOG_PATH", Lookups{ConfigMaps: true}, lookup, withSources); rejection != "" {
+		t.Errorf("Expected missing envFrom source to be skipped, got: %q", rejection)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// EnvKeyMatchExact matches the environment variables named exactly like env_key.
+	EnvKeyMatchExact = "exact"
+	// EnvKeyMatchPrefix matches the environment variables whose name starts with env_key.
+	// The rest of the name is captured as "suffix".
+	EnvKeyMatchPrefix = "prefix"
+	// EnvKeyMatchGlob matches the environment variable names against env_key used as a glob,
+	// where "*" matches any sequence of characters and "?" a single character.
+	EnvKeyMatchGlob = "glob"
+	// EnvKeyMatchRegex matches the environment variable names against env_key used as a
+	// regular expression. The whole name has to match.
+	EnvKeyMatchRegex = "regex"
+	// SuffixCaptureGroup is the capture group holding the rest of the name in prefix mode.
+	SuffixCaptureGroup = "suffix"
+	// patternCacheSize is the number of compiled settings patterns kept across requests.
+	patternCacheSize = 64
+)
+
+// patternCache holds the regular expressions compiled from the settings. Kubewarden sends the
+// settings along with every request, the cache makes each expression compiled once per policy
+// instance instead of once per request.
+//
+//nolint:gochecknoglobals // The policy instance outlives the requests, which is what the cache is for.
+var patternCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// compileSettingsPattern compiles a regular expression built from the settings, reusing the one
+// compiled for a previous request when possible. The cache is emptied when it is full, which only
+// happens when the settings change often.
+func compileSettingsPattern(expr string) (*regexp.Regexp, error) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+
+	if re, ok := patternCache.patterns[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(patternCache.patterns) >= patternCacheSize {
+		clear(patternCache.patterns)
+	}
+	patternCache.patterns[expr] = re
+	return re, nil
+}
+
+// envKeyMatcher matches the names of environment variables against env_key.
+type envKeyMatcher struct {
+	re *regexp.Regexp
+}
+
+// newEnvKeyMatcher compiles the env_key matcher described by the settings.
+func newEnvKeyMatcher(settings Settings) (*envKeyMatcher, error) {
+	var expr string
+	switch settings.EnvKeyMatch {
+	case "", EnvKeyMatchExact:
+		expr = regexp.QuoteMeta(settings.EnvKey)
+	case EnvKeyMatchPrefix:
+		expr = regexp.QuoteMeta(settings.EnvKey) + "(?P<" + SuffixCaptureGroup + ">.*)"
+	case EnvKeyMatchGlob:
+		expr = globToRegexp(settings.EnvKey)
+	case EnvKeyMatchRegex:
+		expr = settings.EnvKey
+	default:
+		return nil, fmt.Errorf("env_key_match must be one of %s, %s, %s, %s",
+			EnvKeyMatchExact, EnvKeyMatchPrefix, EnvKeyMatchGlob, EnvKeyMatchRegex)
+	}
+
+	flags := ""
+	if settings.EnvKeyCaseInsensitive {
+		flags = "(?i)"
+	}
+	re, err := compileSettingsPattern(flags + "^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("env_key is not a valid %s: %w", settings.EnvKeyMatch, err)
+	}
+	return &envKeyMatcher{re: re}, nil
+}
+
+// globToRegexp converts a glob, supporting "*" and "?", into a regular expression.
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return expr.String()
+}
+
+// match checks if an environment variable name matches, returning the named capture groups.
+func (m *envKeyMatcher) match(name string) (map[string]string, bool) {
+	submatches := m.re.FindStringSubmatch(name)
+	if submatches == nil {
+		return nil, false
+	}
+
+	captures := make(map[string]string)
+	for i, group := range m.re.SubexpNames() {
+		if group != "" {
+			captures[group] = submatches[i]
+		}
+	}
+	return captures, true
+}
+
+// captureGroups returns the names of the capture groups available to the annotation keys.
+func (m *envKeyMatcher) captureGroups() []string {
+	var groups []string
+	for _, group := range m.re.SubexpNames() {
+		if group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+// podLogPaths validates a Pod built by podRequest, whose container is changed by modify, and returns
+// the log paths declared by the environment variables named exactly envKey, in declaration order,
+// or the rejection message.
+func podLogPaths(
+	t *testing.T,
+	envKey string,
+	lookups Lookups,
+	lookup ResourceLookup,
+	modify func(pod *corev1.Pod),
+) ([]string, string) {
+	t.Helper()
+
+	settings := Settings{
+		EnvKey:         envKey,
+		OutputMode:     OutputModeList,
+		ListAnnotation: &ListAnnotation{Key: "example.com/log-paths"},
+		NoMatch:        NoMatch{Action: NoMatchIgnore},
+		Lookups:        lookups,
+	}
+	response := validateWithLookupTest(t, podRequest(settings, modify), lookup)
+	if !response.Accepted {
+		if response.Message == nil {
+			t.Fatal("Expected a rejection message")
+		}
+		return nil, *response.Message
+	}
+	if response.MutatedObject == nil {
+		return []string{}, ""
+	}
+
+	var paths []string
+	listed, _ := mutatedMap(t, response, "metadata", "annotations")["example.com/log-paths"].(string)
+	if err := json.Unmarshal([]byte(listed), &paths); err != nil {
+		t.Fatalf("Failed to unmarshal the list annotation %q: %v", listed, err)
+	}
+	return paths, ""
+}
+
+func TestEnvKeyMatcher(t *testing.T) {
+	tests := []struct {
+		name             string
+		settings         Settings
+		envName          string
+		shouldMatch      bool
+		expectedCaptures map[string]string
+	}{
+		{"exact", Settings{EnvKey: "LOG_PATH"}, "LOG_PATH", true, map[string]string{}},
+		{"exact is case sensitive", Settings{EnvKey: "LOG_PATH"}, "log_path", false, nil},
+		{"exact does not match prefixes", Settings{EnvKey: "LOG_PATH"}, "LOG_PATH_ACCESS", false, nil},
+		{
+			"exact case insensitive",
+			Settings{EnvKey: "LOG_PATH", EnvKeyCaseInsensitive: true},
+			"log_path", true, map[string]string{},
+		},
+		{
+			"exact quotes metacharacters",
+			Settings{EnvKey: "LOG.PATH"},
+			"LOG_PATH", false, nil,
+		},
+		{
+			"prefix",
+			Settings{EnvKey: "LOG_PATH_", EnvKeyMatch: EnvKeyMatchPrefix},
+			"LOG_PATH_ACCESS", true, map[string]string{"suffix": "ACCESS"},
+		},
+		{
+			"prefix mismatch",
+			Settings{EnvKey: "LOG_PATH_", EnvKeyMatch: EnvKeyMatchPrefix},
+			"APP_LOG_PATH_ACCESS", false, nil,
+		},
+		{
+			"glob",
+			Settings{EnvKey: "LOG_*_PATH?", EnvKeyMatch: EnvKeyMatchGlob},
+			"LOG_ACCESS_PATH1", true, map[string]string{},
+		},
+		{
+			"glob mismatch",
+			Settings{EnvKey: "LOG_*_PATH?", EnvKeyMatch: EnvKeyMatchGlob},
+			"LOG_ACCESS_PATH", false, nil,
+		},
+		{
+			"regex with named groups",
+			Settings{EnvKey: "LOG_PATH_(?P<name>[A-Z]+)", EnvKeyMatch: EnvKeyMatchRegex},
+			"LOG_PATH_ERROR", true, map[string]string{"name": "ERROR"},
+		},
+		{
+			"regex must match the whole name",
+			Settings{EnvKey: "LOG_PATH_(?P<name>[A-Z]+)", EnvKeyMatch: EnvKeyMatchRegex},
+			"LOG_PATH_ERROR_2", false, nil,
+		},
+		{
+			"regex case insensitive",
+			Settings{EnvKey: "log_path_(?P<name>[a-z]+)", EnvKeyMatch: EnvKeyMatchRegex, EnvKeyCaseInsensitive: true},
+			"LOG_PATH_ERROR", true, map[string]string{"name": "ERROR"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := newEnvKeyMatcher(test.settings)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			captures, matched := matcher.match(test.envName)
+			if matched != test.shouldMatch {
+				t.Fatalf("Expected match to be %v, got %v", test.shouldMatch, matched)
+			}
+			if len(captures) != len(test.expectedCaptures) {
+				t.Fatalf("Expected captures %v, got %v", test.expectedCaptures, captures)
+			}
+			for group, expected := range test.expectedCaptures {
+				if captures[group] != expected {
+					t.Errorf("Expected capture %s to be %s, got %s", group, expected, captures[group])
+				}
+			}
+		})
+	}
+}
+
+func TestEnvKeyCapturesInAnnotationKeys(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH_(?P<name>[A-Z]+)",
+		EnvKeyMatch:         EnvKeyMatchRegex,
+		AnnotationBase:      "logs.example.com/{name}-path",
+		AnnotationExtFormat: "logs.example.com/{name}-path-%d",
+	}
+	pod := corev1.Pod{
+		Spec: &corev1.PodSpec{
+			Containers: []*corev1.Container{
+				{
+					Name: stringPtr("app"),
+					Env: []*corev1.EnvVar{
+						{Name: stringPtr("LOG_PATH_ACCESS"), Value: "/var/log/access.log"},
+						{Name: stringPtr("LOG_PATH_ERROR"), Value: "/var/log/error.log,/var/log/fatal.log"},
+						{Name: stringPtr("LOG_PATH"), Value: "/var/log/ignored.log"},
+					},
+				},
+			},
+		},
+	}
+
+	logPaths, err := collectLogPaths(pod.Spec, settings, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	annotations, _, err := getAnnotations(logPaths, settings, requestInfo{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedAnnotations := map[string]string{
+		"logs.example.com/access-path":  "/var/log/access.log",
+		"logs.example.com/error-path":   "/var/log/error.log",
+		"logs.example.com/error-path-1": "/var/log/fatal.log",
+	}
+	if len(annotations) != len(expectedAnnotations) {
+		t.Errorf("Expected annotations %v, got %v", expectedAnnotations, annotations)
+	}
+	for key, expected := range expectedAnnotations {
+		if annotations[key] != expected {
+			t.Errorf("Expected annotation %s to be %s, got %s", key, expected, annotations[key])
+		}
+	}
+}
+
+func TestEnvKeyMatcherIsCompiledOnce(t *testing.T) {
+	settings := Settings{EnvKey: "LOG_PATH_", EnvKeyMatch: EnvKeyMatchPrefix}
+	first, err := newEnvKeyMatcher(settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := newEnvKeyMatcher(settings)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first.re != second.re {
+		t.Error("Expected the env_key pattern to be reused across requests")
+	}
+
+	for i := range patternCacheSize + 1 {
+		if _, err = compileSettingsPattern(fmt.Sprintf("^pattern-%d$", i)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if size := len(patternCache.patterns); size > patternCacheSize {
+		t.Errorf("Expected at most %d cached patterns, got %d", patternCacheSize, size)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultAutoMountVolume is the default name of the volume holding the mounted log directories.
+	DefaultAutoMountVolume = "log-env-to-annotation-logs"
+	// DefaultAutoMountAllowedDir is the default directory below which log directories are mounted.
+	DefaultAutoMountAllowedDir = "/var/log"
+	// dnsLabelMaxLength is the maximum length of a volume name.
+	dnsLabelMaxLength = 63
+)
+
+// AutoMount configures the volume mounts added for the log directories that are not on a volume,
+// so that the log files are visible to node-level collectors.
+type AutoMount struct {
+	// Enabled adds the volume and the volume mounts.
+	Enabled bool `json:"enabled,omitempty"`
+	// Volume is the name of the volume. Defaults to DefaultAutoMountVolume.
+	Volume string `json:"volume,omitempty"`
+	// HostPath makes the volume a hostPath volume of this directory instead of an emptyDir volume.
+	// It requires a SubPathExpr unique to each Pod.
+	HostPath string `json:"host_path,omitempty"`
+	// SubPathExpr is the prefix of the subPathExpr of the volume mounts, such as $(POD_NAME).
+	// The environment variables it references must be defined by the containers.
+	SubPathExpr string `json:"sub_path_expr,omitempty"`
+	// AllowedDirs are the directories below which log directories are mounted, the mounts hiding
+	// the files shipped by the image there. Defaults to DefaultAutoMountAllowedDir.
+	AllowedDirs []string `json:"allowed_dirs,omitempty"`
+}
+
+//nolint:gochecknoglobals // Read-only pattern of the Kubernetes volume names.
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Valid validates the auto mount settings.
+func (a *AutoMount) Valid() error {
+	if !a.Enabled {
+		if a.Volume != "" || a.HostPath != "" || a.SubPathExpr != "" || len(a.AllowedDirs) > 0 {
+			return errors.New("auto_mount settings require auto_mount enabled")
+		}
+		return nil
+	}
+	if len(a.volumeName()) > dnsLabelMaxLength || !dnsLabelPattern.MatchString(a.volumeName()) {
+		return fmt.Errorf("auto_mount volume %q must be a lower case DNS label", a.volumeName())
+	}
+	if a.HostPath != "" && !path.IsAbs(a.HostPath) {
+		return errors.New("auto_mount host_path must be an absolute path")
+	}
+	for _, dir := range a.AllowedDirs {
+		if !path.IsAbs(dir) || path.Clean(dir) == "/" {
+			return fmt.Errorf("auto_mount allowed_dirs %q must be an absolute path other than /", dir)
+		}
+	}
+	if strings.HasPrefix(a.SubPathExpr, "/") || strings.Contains(a.SubPathExpr, "..") {
+		return errors.New("auto_mount sub_path_expr must be a relative path without ..")
+	}
+	// Without a per-pod sub path, all the Pods of a node would write to the same host directories
+	if a.HostPath != "" && !strings.Contains(a.SubPathExpr, "$(") {
+		return errors.New("auto_mount sub_path_expr must reference an environment variable, " +
+			"such as $(POD_NAME), when host_path is set")
+	}
+	return nil
+}
+
+// volumeName returns the name of the volume holding the mounted log directories.
+func (a *AutoMount) volumeName() string {
+	if a.Volume == "" {
+		return DefaultAutoMountVolume
+	}
+	return a.Volume
+}
+
+// allowedDir checks if a log directory can be mounted, being one of the allowed directories or below one.
+func (a *AutoMount) allowedDir(dir string) bool {
+	allowed := a.AllowedDirs
+	if len(allowed) == 0 {
+		allowed = []string{DefaultAutoMountAllowedDir}
+	}
+	for _, parent := range allowed {
+		parent = path.Clean(parent)
+		if dir == parent || strings.HasPrefix(dir, parent+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// volume returns the raw volume holding the mounted log directories.
+func (a *AutoMount) volume() map[string]interface{} {
+	volume := map[string]interface{}{"name": a.volumeName()}
+	if a.HostPath != "" {
+		volume["hostPath"] = map[string]interface{}{"path": a.HostPath, "type": "DirectoryOrCreate"}
+	} else {
+		volume["emptyDir"] = map[string]interface{}{}
+	}
+	return volume
+}
+
+// volumeMount returns the raw volume mount of a log directory. Each directory gets its own
+// sub path of the volume.
+func (a *AutoMount) volumeMount(dir string) map[string]interface{} {
+	mount := map[string]interface{}{"name": a.volumeName(), "mountPath": dir}
+	if a.SubPathExpr != "" {
+		mount["subPathExpr"] = strings.TrimSuffix(a.SubPathExpr, "/") + "/" + slugify(dir)
+	} else {
+		mount["subPath"] = slugify(dir)
+	}
+	return mount
+}
+
+// mountLogDirectories adds to a raw pod spec a volume mount for the directory of each absolute log path
+// that is below an allowed directory and not under an existing volume mount of its container, along with
+// the volume they share. The other directories are left alone, since the empty volume would hide the files
+// the image ships there. It returns true when the pod spec was changed.
+func mountLogDirectories(spec map[string]interface{}, logPaths []logPath, autoMount AutoMount) bool {
+	if !autoMount.Enabled || spec == nil {
+		return false
+	}
+
+	dirs := make(map[string][]string)
+	for _, entry := range logPaths {
+		cleaned := path.Clean(entry.Path)
+		if !path.IsAbs(cleaned) || !autoMount.allowedDir(path.Dir(cleaned)) {
+			continue
+		}
+		dirs[entry.Container] = append(dirs[entry.Container], path.Dir(cleaned))
+	}
+
+	mounted := false
+	for _, field := range []string{"containers", "initContainers"} {
+		containers, _ := spec[field].([]interface{})
+		for _, item := range containers {
+			container, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := container["name"].(string)
+			if mountContainerDirectories(container, dirs[name], autoMount) {
+				mounted = true
+			}
+		}
+	}
+
+	if mounted && !hasVolume(spec, autoMount.volumeName()) {
+		volumes, _ := spec["volumes"].([]interface{})
+		spec["volumes"] = append(volumes, autoMount.volume())
+	}
+	return mounted
+}
+
+// mountContainerDirectories adds the volume mounts of the given directories to a raw container,
+// skipping the ones under an existing mount. It returns true when a volume mount was added.
+func mountContainerDirectories(container map[string]interface{}, dirs []string, autoMount AutoMount) bool {
+	// Parents are mounted first, so that their subdirectories are skipped
+	sort.Strings(dirs)
+
+	mounts, _ := container["volumeMounts"].([]interface{})
+	mounted := false
+	for _, dir := range dirs {
+		if underVolumeMount(dir, mounts) {
+			continue
+		}
+		mounts = append(mounts, autoMount.volumeMount(dir))
+		mounted = true
+	}
+	if mounted {
+		container["volumeMounts"] = mounts
+	}
+	return mounted
+}
+
+// underVolumeMount checks if a directory is one of the mount paths of the raw volume mounts or below one.
+func underVolumeMount(dir string, mounts []interface{}) bool {
+	for _, item := range mounts {
+		mount, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mountPath, _ := mount["mountPath"].(string)
+		if mountPath == "" {
+			continue
+		}
+		mountPath = path.Clean(mountPath)
+		if dir == mountPath || strings.HasPrefix(dir, strings.TrimSuffix(mountPath, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVolume checks if a raw pod spec declares a volume with the given name.
+func hasVolume(spec map[string]interface{}, name string) bool {
+	volumes, _ := spec["volumes"].([]interface{})
+	for _, item := range volumes {
+		if volume, ok := item.(map[string]interface{}); ok && volume["name"] == name {
+			return true
+		}
+	}
+	return false
+}
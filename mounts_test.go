@@ -0,0 +1,330 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+func TestAutoMountWorkload(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		AutoMount:           AutoMount{Enabled: true},
+	}
+
+	response := validateFixture(t, "test_data/deployment-env.json", settings)
+	spec := mutatedMap(t, response, "spec", "template", "spec")
+
+	expectedVolumes := []interface{}{
+		map[string]interface{}{"name": DefaultAutoMountVolume, "emptyDir": map[string]interface{}{}},
+	}
+	if !reflect.DeepEqual(spec["volumes"], expectedVolumes) {
+		t.Errorf("Expected volumes %v, got %v", expectedVolumes, spec["volumes"])
+	}
+
+	container := spec["containers"].([]interface{})[0].(map[string]interface{})
+	expectedMounts := []interface{}{
+		map[string]interface{}{"name": DefaultAutoMountVolume, "mountPath": "/var/log", "subPath": "var-log"},
+	}
+	if !reflect.DeepEqual(container["volumeMounts"], expectedMounts) {
+		t.Errorf("Expected volume mounts %v, got %v", expectedMounts, container["volumeMounts"])
+	}
+
+	annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+	if annotations["co_elastic_logs_path"] != "/var/log/app.log" {
+		t.Errorf("Expected the pod template to be annotated, got %v", annotations)
+	}
+}
+
+func TestAutoMountPod(t *testing.T) {
+	pod := corev1.Pod{
+		Spec: &corev1.PodSpec{
+			Containers: []*corev1.Container{
+				{
+					Name: stringPtr("app"),
+					Env: []*corev1.EnvVar{
+						{Name: stringPtr("LOG_PATH"), Value: "/data/logs/app.log,/srv/app/logs/audit.log,relative.log"},
+					},
+					VolumeMounts: []*corev1.VolumeMount{{Name: stringPtr("data"), MountPath: stringPtr("/data")}},
+				},
+				{
+					Name: stringPtr("worker"),
+					Env: []*corev1.EnvVar{
+						{Name: stringPtr("LOG_PATH"), Value: "/srv/app/logs/worker.log,/srv/app/logs/gc/gc.log"},
+					},
+				},
+			},
+			Volumes: []*corev1.Volume{{Name: stringPtr("data")}},
+		},
+	}
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PodOwnerKinds:       []string{"none"},
+		AutoMount: AutoMount{
+			Enabled:     true,
+			Volume:      "logs",
+			HostPath:    "/var/log/pods-extra",
+			SubPathExpr: "$(POD_NAME)",
+			AllowedDirs: []string{"/srv"},
+		},
+	}
+	request := kubewarden_protocol.ValidationRequest{
+		Request: kubewarden_protocol.KubernetesAdmissionRequest{
+			Kind:      kubewarden_protocol.GroupVersionKind{Kind: "Pod"},
+			Operation: "CREATE",
+			Object:    mustMarshalJSON(pod),
+		},
+		Settings: mustMarshalJSON(settings),
+	}
+
+	response, err := validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	spec := mutatedMap(t, response, "spec")
+
+	volumes := spec["volumes"].([]interface{})
+	expectedVolume := map[string]interface{}{
+		"name":     "logs",
+		"hostPath": map[string]interface{}{"path": "/var/log/pods-extra", "type": "DirectoryOrCreate"},
+	}
+	if len(volumes) != 2 || !reflect.DeepEqual(volumes[1], expectedVolume) {
+		t.Errorf("Expected the volume %v to be added, got %v", expectedVolume, volumes)
+	}
+
+	containers := spec["containers"].([]interface{})
+	appMounts := containers[0].(map[string]interface{})["volumeMounts"].([]interface{})
+	expectedAppMounts := []interface{}{
+		map[string]interface{}{"name": "data", "mountPath": "/data"},
+		map[string]interface{}{"name": "logs", "mountPath": "/srv/app/logs", "subPathExpr": "$(POD_NAME)/srv-app-logs"},
+	}
+	if !reflect.DeepEqual(appMounts, expectedAppMounts) {
+		t.Errorf("Expected volume mounts %v, got %v", expectedAppMounts, appMounts)
+	}
+
+	// The subdirectories of a mounted directory are not mounted again
+	workerMounts := containers[1].(map[string]interface{})["volumeMounts"].([]interface{})
+	expectedWorkerMounts := []interface{}{
+		map[string]interface{}{"name": "logs", "mountPath": "/srv/app/logs", "subPathExpr": "$(POD_NAME)/srv-app-logs"},
+	}
+	if !reflect.DeepEqual(workerMounts, expectedWorkerMounts) {
+		t.Errorf("Expected volume mounts %v, got %v", expectedWorkerMounts, workerMounts)
+	}
+
+	// The spec of existing Pods cannot be changed
+	request.Request.Operation = "UPDATE"
+	response, err = validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	spec = mutatedMap(t, response, "spec")
+	if len(spec["volumes"].([]interface{})) != 1 {
+		t.Errorf("Expected no volume to be added on UPDATE, got %v", spec["volumes"])
+	}
+}
+
+func TestAutoMountImmutableTemplate(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		AutoMount:           AutoMount{Enabled: true},
+	}
+
+	response := validateFixture(t, "test_data/job-env.json", settings)
+	if spec := mutatedMap(t, response, "spec", "template", "spec"); spec["volumes"] == nil {
+		t.Errorf("Expected the volume to be added when the Job is created, got %v", spec)
+	}
+
+	// The pod template of a Job cannot be changed afterwards
+	admissionRequest := loadFixture(t, "test_data/job-env.json", nil)
+	admissionRequest.Operation = "UPDATE"
+	admissionRequest.OldObject = admissionRequest.Object
+	response, err := validateTest(t, kubewarden_protocol.ValidationRequest{
+		Request:  admissionRequest,
+		Settings: mustMarshalJSON(settings),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+}
+
+func TestAutoMountSelectedPaths(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PathSelection:       PathSelection{MaxPaths: 1},
+		AutoMount:           AutoMount{Enabled: true, AllowedDirs: []string{"/var/log", "/srv"}},
+	}
+	// Only the log path kept by the selection is mounted
+	moveErrorLog := func(object map[string]interface{}) {
+		container, _ := lookupMap(object, []string{"spec", "template", "spec", "containers", "0"})
+		env := container["env"].([]interface{})
+		env[1].(map[string]interface{})["value"] = "/srv/extra/err.log"
+	}
+
+	tests := []struct {
+		fixture       string
+		modify        func(object map[string]interface{})
+		containerPath []string
+		expectedMount map[string]interface{}
+	}{
+		{
+			"test_data/pod-mulitiple-env.json",
+			nil,
+			[]string{"spec", "containers", "0"},
+			map[string]interface{}{
+				"name":      DefaultAutoMountVolume,
+				"mountPath": "/var/log/apps/common-api-bff",
+				"subPath":   "var-log-apps-common-api-bff",
+			},
+		},
+		{
+			"test_data/deployment-env.json",
+			moveErrorLog,
+			[]string{"spec", "template", "spec", "containers", "0"},
+			map[string]interface{}{"name": DefaultAutoMountVolume, "mountPath": "/var/log", "subPath": "var-log"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			response := validateModifiedFixture(t, test.fixture, test.modify, settings)
+			container := mutatedMap(t, response, test.containerPath...)
+			expectedMounts := []interface{}{test.expectedMount}
+			if !reflect.DeepEqual(container["volumeMounts"], expectedMounts) {
+				t.Errorf("Expected volume mounts %v, got %v", expectedMounts, container["volumeMounts"])
+			}
+		})
+	}
+}
+
+func TestAutoMountAllowedDirs(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		AutoMount:           AutoMount{Enabled: true},
+	}
+
+	// Mounting an empty volume on /app would hide the application shipped by the image
+	response, err := validateTest(t, podRequest(settings, nil,
+		&corev1.EnvVar{Name: stringPtr("LOG_PATH"), Value: "/app/app.log,/var/log/app/app.log,/var/log.log"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	container := mutatedMap(t, response, "spec", "containers", "0")
+	expectedMounts := []interface{}{
+		map[string]interface{}{"name": DefaultAutoMountVolume, "mountPath": "/var/log/app", "subPath": "var-log-app"},
+	}
+	if !reflect.DeepEqual(container["volumeMounts"], expectedMounts) {
+		t.Errorf("Expected volume mounts %v, got %v", expectedMounts, container["volumeMounts"])
+	}
+
+	settings.AutoMount.AllowedDirs = []string{"/app"}
+	response, err = validateTest(t, podRequest(settings, nil,
+		&corev1.EnvVar{Name: stringPtr("LOG_PATH"), Value: "/app/app.log"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	container = mutatedMap(t, response, "spec", "containers", "0")
+	expectedMounts = []interface{}{
+		map[string]interface{}{"name": DefaultAutoMountVolume, "mountPath": "/app", "subPath": "app"},
+	}
+	if !reflect.DeepEqual(container["volumeMounts"], expectedMounts) {
+		t.Errorf("Expected volume mounts %v, got %v", expectedMounts, container["volumeMounts"])
+	}
+}
+
+func TestAutoMountIsIdempotent(t *testing.T) {
+	spec := map[string]interface{}{
+		"containers": []interface{}{map[string]interface{}{"name": "app"}},
+	}
+	logPaths := []logPath{{Container: "app", Path: "/var/log/app/app.log"}}
+	autoMount := AutoMount{Enabled: true}
+
+	if !mountLogDirectories(spec, logPaths, autoMount) {
+		t.Errorf("Expected the log directory to be mounted")
+	}
+	if mountLogDirectories(spec, logPaths, autoMount) {
+		t.Errorf("Expected the mounted log directory to be left unchanged, got %v", spec)
+	}
+	if volumes := spec["volumes"].([]interface{}); len(volumes) != 1 {
+		t.Errorf("Expected a single volume, got %v", volumes)
+	}
+}
+
+func TestAutoMountSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		autoMount     AutoMount
+		expectedError string
+	}{
+		{"disabled with settings", AutoMount{Volume: "logs"}, "auto_mount settings require auto_mount enabled"},
+		{
+			"invalid volume name",
+			AutoMount{Enabled: true, Volume: "Logs_Volume"},
+			"auto_mount volume \"Logs_Volume\" must be a lower case DNS label",
+		},
+		{
+			"relative host path",
+			AutoMount{Enabled: true, HostPath: "var/log"},
+			"auto_mount host_path must be an absolute path",
+		},
+		{
+			"host path without sub path expression",
+			AutoMount{Enabled: true, HostPath: "/var/log/pods-extra"},
+			"auto_mount sub_path_expr must reference an environment variable, such as $(POD_NAME), when host_path is set",
+		},
+		{
+			"host path with a constant sub path expression",
+			AutoMount{Enabled: true, HostPath: "/var/log/pods-extra", SubPathExpr: "logs"},
+			"auto_mount sub_path_expr must reference an environment variable, such as $(POD_NAME), when host_path is set",
+		},
+		{
+			"relative allowed directory",
+			AutoMount{Enabled: true, AllowedDirs: []string{"var/log"}},
+			"auto_mount allowed_dirs \"var/log\" must be an absolute path other than /",
+		},
+		{
+			"root allowed directory",
+			AutoMount{Enabled: true, AllowedDirs: []string{"/"}},
+			"auto_mount allowed_dirs \"/\" must be an absolute path other than /",
+		},
+		{
+			"absolute sub path expression",
+			AutoMount{Enabled: true, SubPathExpr: "/$(POD_NAME)"},
+			"auto_mount sub_path_expr must be a relative path without ..",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_%d",
+				AutoMount:           test.autoMount,
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+)
+
+const (
+	// NoMatchFallback adds the fallback annotations when no log path is found.
+	NoMatchFallback = "fallback"
+	// NoMatchIgnore admits the request unchanged when no log path is found, apart from
+	// the removal of the stale annotations and labels when prune_stale is enabled.
+	NoMatchIgnore = "ignore"
+	// NoMatchReject rejects the request when no log path is found.
+	NoMatchReject = "reject"
+)
+
+// NoMatch configures what happens when no container declares a log path.
+type NoMatch struct {
+	// Action is either fallback (default), ignore or reject.
+	Action string `json:"action,omitempty"`
+	// Annotations are added by the fallback action.
+	// Defaults to co.elastic.logs/enabled: "true".
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Valid validates the no_match settings.
+func (n *NoMatch) Valid() error {
+	switch n.Action {
+	case "", NoMatchFallback:
+	case NoMatchIgnore, NoMatchReject:
+		if len(n.Annotations) > 0 {
+			return errors.New("no_match annotations can only be set when action is " + NoMatchFallback)
+		}
+	default:
+		return fmt.Errorf("no_match action must be one of %s, %s, %s", NoMatchFallback, NoMatchIgnore, NoMatchReject)
+	}
+
+	for key := range n.Annotations {
+		if err := validateAnnotationKey(key); err != nil {
+			return fmt.Errorf("no_match annotations: %w", err)
+		}
+	}
+	return nil
+}
+
+// fallbackAnnotations returns the annotations added by the fallback action.
+func (n *NoMatch) fallbackAnnotations() map[string]string {
+	if len(n.Annotations) == 0 {
+		return map[string]string{LogEnabledAnnotation: LogEnabledValue}
+	}
+	return maps.Clone(n.Annotations)
+}
+
+// checkNoMatch applies the no_match action when no log path was found. It returns false when
+// the object must be left unchanged, and an error when the request must be rejected.
+func checkNoMatch(logPaths []logPath, settings Settings) (bool, error) {
+	if len(logPaths) > 0 {
+		return true, nil
+	}
+
+	switch settings.NoMatch.Action {
+	case NoMatchIgnore:
+		return false, nil
+	case NoMatchReject:
+		if settings.EnvKeyMatch == "" || settings.EnvKeyMatch == EnvKeyMatchExact {
+			return false, fmt.Errorf("no container defines the environment variable %s", settings.EnvKey)
+		}
+		return false, fmt.Errorf("no container defines an environment variable matching the %s pattern %s",
+			settings.EnvKeyMatch, settings.EnvKey)
+	default:
+		return true, nil
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNoMatchActions(t *testing.T) {
+	tests := []struct {
+		name                string
+		noMatch             NoMatch
+		additional          map[string]interface{}
+		expectedAnnotations map[string]string
+		expectedRejection   string
+	}{
+		{
+			name:                "default fallback",
+			expectedAnnotations: map[string]string{LogEnabledAnnotation: LogEnabledValue},
+		},
+		{
+			name:       "configured fallback",
+			noMatch:    NoMatch{Action: NoMatchFallback, Annotations: map[string]string{"logs.example.com/stdout": "true"}},
+			additional: map[string]interface{}{"example.com/team": "platform"},
+			expectedAnnotations: map[string]string{
+				"logs.example.com/stdout": "true",
+				"example.com/team":        "platform",
+			},
+		},
+		{
+			name:       "ignore",
+			noMatch:    NoMatch{Action: NoMatchIgnore},
+			additional: map[string]interface{}{"example.com/team": "platform"},
+		},
+		{
+			name:              "reject",
+			noMatch:           NoMatch{Action: NoMatchReject},
+			expectedRejection: "no container defines the environment variable vestack_varlog",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:                "vestack_varlog",
+				AnnotationBase:        "co_elastic_logs_path",
+				AnnotationExtFormat:   "co_elastic_logs_path_ext_%d",
+				AdditionalAnnotations: test.additional,
+				NoMatch:               test.noMatch,
+			}
+
+			response := validateFixture(t, "test_data/pod-no-env.json", settings)
+			switch {
+			case test.expectedRejection != "":
+				assertRejection(t, response, test.expectedRejection)
+			case test.expectedAnnotations == nil:
+				if !response.Accepted {
+					t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+				}
+				assertNoMutation(t, response)
+			default:
+				assertMutation(t, response, test.expectedAnnotations)
+			}
+		})
+	}
+}
+
+func TestNoMatchWorkloads(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH_",
+		EnvKeyMatch:         EnvKeyMatchPrefix,
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		NoMatch:             NoMatch{Action: NoMatchReject},
+	}
+	response := validateFixture(t, "test_data/deployment-env.json", settings)
+	assertRejection(t, response, "no container defines an environment variable matching the prefix pattern LOG_PATH_")
+
+	settings.NoMatch = NoMatch{Action: NoMatchIgnore}
+	response = validateFixture(t, "test_data/deployment-env.json", settings)
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+}
+
+func TestNoMatchSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		noMatch       NoMatch
+		expectedError string
+	}{
+		{"unknown action", NoMatch{Action: "drop"}, "no_match action must be one of fallback, ignore, reject"},
+		{
+			"annotations without fallback",
+			NoMatch{Action: NoMatchIgnore, Annotations: map[string]string{"logs": "true"}},
+			"no_match annotations can only be set when action is fallback",
+		},
+		{
+			"invalid annotation key",
+			NoMatch{Annotations: map[string]string{"logs/": "true"}},
+			"no_match annotations: annotation key \"logs/\" is not valid: name part must be non-empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_%d",
+				NoMatch:             test.noMatch,
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// OutputModeNumbered assigns the log paths to annotation_base and annotation_ext_format.
+	OutputModeNumbered = "numbered"
+	// OutputModeList writes all the log paths to the single annotation configured by list_annotation.
+	OutputModeList = "list"
+	// OutputModeBoth writes both the numbered annotations and the list annotation.
+	OutputModeBoth = "both"
+	// ListFormatJSON writes the log paths as a JSON array.
+	ListFormatJSON = "json"
+	// ListFormatJoined writes the log paths joined by the separator.
+	ListFormatJoined = "joined"
+	// defaultListSeparator is the separator used by the joined format when none is configured.
+	defaultListSeparator = ","
+)
+
+// ListAnnotation configures the annotation holding all the log paths.
+type ListAnnotation struct {
+	// Key is the annotation key.
+	Key string `json:"key"`
+	// Format is either json (default), which writes a JSON array, or joined, which joins
+	// the log paths with Separator.
+	Format string `json:"format,omitempty"`
+	// Separator joins the log paths in the joined format. Defaults to a comma.
+	Separator string `json:"separator,omitempty"`
+	// IncludeContainer writes the name of the container next to each log path, JSON format only.
+	IncludeContainer bool `json:"include_container,omitempty"`
+	// IncludeIndex writes the 0-based index of each log path next to it, JSON format only.
+	IncludeIndex bool `json:"include_index,omitempty"`
+}
+
+// listEntry is a log path of the JSON list annotation, along with its optional details.
+type listEntry struct {
+	Path      string `json:"path"`
+	Container string `json:"container,omitempty"`
+	Index     *int   `json:"index,omitempty"`
+}
+
+// Valid validates the list annotation settings.
+func (l *ListAnnotation) Valid() error {
+	if l.Key == "" {
+		return errors.New("list_annotation key cannot be empty")
+	}
+	if err := validateAnnotationKey(l.Key); err != nil {
+		return fmt.Errorf("list_annotation: %w", err)
+	}
+
+	switch l.Format {
+	case "", ListFormatJSON:
+	case ListFormatJoined:
+		if l.IncludeContainer || l.IncludeIndex {
+			return errors.New("list_annotation include_container and include_index require the " +
+				ListFormatJSON + " format")
+		}
+	default:
+		return fmt.Errorf("list_annotation format must be either %s or %s", ListFormatJSON, ListFormatJoined)
+	}
+	return nil
+}
+
+// validOutputMode validates the output mode and the list annotation it requires.
+func (s *Settings) validOutputMode() error {
+	switch s.OutputMode {
+	case "", OutputModeNumbered:
+		return nil
+	case OutputModeList, OutputModeBoth:
+		if s.ListAnnotation == nil {
+			return fmt.Errorf("list_annotation must be set when output_mode is %s", s.OutputMode)
+		}
+		return s.ListAnnotation.Valid()
+	default:
+		return fmt.Errorf("output_mode must be one of %s, %s, %s", OutputModeNumbered, OutputModeList, OutputModeBoth)
+	}
+}
+
+// numberedOutput checks if the log paths are written to the numbered annotations.
+func (s *Settings) numberedOutput() bool {
+	return s.OutputMode != OutputModeList
+}
+
+// listOutput checks if the log paths are written to the list annotation.
+func (s *Settings) listOutput() bool {
+	return s.ListAnnotation != nil && (s.OutputMode == OutputModeList || s.OutputMode == OutputModeBoth)
+}
+
+// listAnnotationValue returns the value of the annotation holding all the log paths.
+func listAnnotationValue(logPaths []logPath, list ListAnnotation) (string, error) {
+	if list.Format == ListFormatJoined {
+		separator := list.Separator
+		if separator == "" {
+			separator = defaultListSeparator
+		}
+		paths := make([]string, 0, len(logPaths))
+		for _, path := range logPaths {
+			paths = append(paths, path.Path)
+		}
+		return strings.Join(paths, separator), nil
+	}
+
+	var value interface{}
+	if list.IncludeContainer || list.IncludeIndex {
+		entries := make([]listEntry, 0, len(logPaths))
+		for index, path := range logPaths {
+			entry := listEntry{Path: path.Path}
+			if list.IncludeContainer {
+				entry.Container = path.Container
+			}
+			if list.IncludeIndex {
+				entry.Index = &index
+			}
+			entries = append(entries, entry)
+		}
+		value = entries
+	} else {
+		paths := make([]string, 0, len(logPaths))
+		for _, path := range logPaths {
+			paths = append(paths, path.Path)
+		}
+		value = paths
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode list_annotation: %w", err)
+	}
+	return string(encoded), nil
+}
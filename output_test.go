@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestListAnnotationValue(t *testing.T) {
+	logPaths := []logPath{
+		{Container: "app", Path: "/var/log/app.log"},
+		{Container: "worker", Path: "/var/log/worker.log"},
+	}
+
+	tests := []struct {
+		name          string
+		list          ListAnnotation
+		expectedValue string
+	}{
+		{"json", ListAnnotation{}, `["/var/log/app.log","/var/log/worker.log"]`},
+		{
+			"json with container and index",
+			ListAnnotation{IncludeContainer: true, IncludeIndex: true},
+			`[{"path":"/var/log/app.log","container":"app","index":0},` +
+				`{"path":"/var/log/worker.log","container":"worker","index":1}]`,
+		},
+		{
+			"json with index",
+			ListAnnotation{IncludeIndex: true},
+			`[{"path":"/var/log/app.log","index":0},{"path":"/var/log/worker.log","index":1}]`,
+		},
+		{"joined", ListAnnotation{Format: ListFormatJoined}, "/var/log/app.log,/var/log/worker.log"},
+		{
+			"joined with separator",
+			ListAnnotation{Format: ListFormatJoined, Separator: ";"},
+			"/var/log/app.log;/var/log/worker.log",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := listAnnotationValue(logPaths, test.list)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if value != test.expectedValue {
+				t.Errorf("Expected %s, got %s", test.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	list := &ListAnnotation{Key: "logs.example.com/paths", Format: ListFormatJoined}
+
+	tests := []struct {
+		name                string
+		settings            Settings
+		expectedAnnotations map[string]string
+	}{
+		{
+			name: "list",
+			settings: Settings{
+				EnvKey:         "vestack_varlog",
+				OutputMode:     OutputModeList,
+				ListAnnotation: list,
+			},
+			expectedAnnotations: map[string]string{
+				"logs.example.com/paths": "/var/log/app.log,/var/log/worker.log,/var/log/worker-err.log",
+			},
+		},
+		{
+			name: "both",
+			settings: Settings{
+				EnvKey:              "vestack_varlog",
+				AnnotationBase:      "co_elastic_logs_path",
+				AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+				OutputMode:          OutputModeBoth,
+				ListAnnotation:      list,
+			},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/worker.log",
+				"co_elastic_logs_path_ext_2": "/var/log/worker-err.log",
+				"logs.example.com/paths":     "/var/log/app.log,/var/log/worker.log,/var/log/worker-err.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid, err := test.settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid, got error: %v", err)
+			}
+
+			response := validateFixture(t, "test_data/pod-multiple-containers.json", test.settings)
+			assertMutation(t, response, test.expectedAnnotations)
+		})
+	}
+}
+
+func TestOutputModeSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		outputMode    string
+		list          *ListAnnotation
+		expectedError string
+	}{
+		{"unknown mode", "single", nil, "output_mode must be one of numbered, list, both"},
+		{"missing list annotation", OutputModeList, nil, "list_annotation must be set when output_mode is list"},
+		{"empty key", OutputModeBoth, &ListAnnotation{}, "list_annotation key cannot be empty"},
+		{
+			"invalid key", OutputModeList, &ListAnnotation{Key: "log paths"},
+			"list_annotation: annotation key \"log paths\" is not valid: name part must consist of alphanumeric " +
+				"characters, '-', '_' or '.', and must start and end with an alphanumeric character",
+		},
+		{
+			"unknown format", OutputModeList, &ListAnnotation{Key: "paths", Format: "yaml"},
+			"list_annotation format must be either json or joined",
+		},
+		{
+			"details in joined format", OutputModeList,
+			&ListAnnotation{Key: "paths", Format: ListFormatJoined, IncludeContainer: true},
+			"list_annotation include_container and include_index require the json format",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_%d",
+				OutputMode:          test.outputMode,
+				ListAnnotation:      test.list,
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// PathActionReject rejects the request when a log path violates the path policy.
+	PathActionReject = "reject"
+	// PathActionDrop drops the log paths violating the path policy.
+	PathActionDrop = "drop"
+	// PathActionWarn keeps the log paths violating the path policy and logs a warning.
+	PathActionWarn = "warn"
+	// globAnySegments matches any number of path segments inside of a path pattern.
+	globAnySegments = "**"
+)
+
+// PathPolicy restricts the log paths that can be written to the annotations.
+type PathPolicy struct {
+	// Normalize writes the log paths cleaned with path.Clean. The rules are always
+	// checked against the cleaned paths.
+	Normalize bool `json:"normalize,omitempty"`
+	// RequireAbsolute only accepts absolute log paths.
+	RequireAbsolute bool `json:"require_absolute,omitempty"`
+	// Allow only accepts the log paths matching one of these patterns.
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists the rules applied to the log paths matching their pattern.
+	Deny []PathRule `json:"deny,omitempty"`
+	// Action is applied to the relative log paths, to the log paths matching no allow pattern and
+	// by the deny rules without an action: reject (default), drop or warn.
+	Action string `json:"action,omitempty"`
+}
+
+// PathRule denies the log paths matching a pattern.
+type PathRule struct {
+	// Pattern is a path prefix, such as /var/lib/kubelet, or a glob, such as /etc/**
+	// or /var/log/*.key, where ** matches any number of path segments.
+	Pattern string `json:"pattern"`
+	// Action is either reject, drop or warn. Defaults to the action of the path policy.
+	Action string `json:"action,omitempty"`
+}
+
+// validPathAction validates the action of the given setting.
+func validPathAction(name, action string) error {
+	switch action {
+	case "", PathActionReject, PathActionDrop, PathActionWarn:
+		return nil
+	default:
+		return fmt.Errorf("%s must be one of %s, %s, %s", name, PathActionReject, PathActionDrop, PathActionWarn)
+	}
+}
+
+// validPathPattern validates a path prefix or glob.
+func validPathPattern(name, pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return errors.New(name + " patterns cannot be empty")
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("%s pattern %q is not valid: %w", name, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Valid validates the path policy.
+func (p *PathPolicy) Valid() error {
+	if err := validPathAction("path_policy action", p.Action); err != nil {
+		return err
+	}
+	for _, pattern := range p.Allow {
+		if err := validPathPattern("path_policy allow", pattern); err != nil {
+			return err
+		}
+	}
+	for _, rule := range p.Deny {
+		if err := validPathPattern("path_policy deny", rule.Pattern); err != nil {
+			return err
+		}
+		if err := validPathAction("path_policy deny action", rule.Action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// action returns the action of a rule, falling back to the one of the policy.
+func (p *PathPolicy) action(ruleAction string) string {
+	switch {
+	case ruleAction != "":
+		return ruleAction
+	case p.Action != "":
+		return p.Action
+	default:
+		return PathActionReject
+	}
+}
+
+// violation returns the reason why a cleaned log path violates the policy, and the action to apply.
+// The reason is empty when the log path is accepted.
+func (p *PathPolicy) violation(cleaned string) (string, string) {
+	if p.RequireAbsolute && !path.IsAbs(cleaned) {
+		return "is not absolute", p.action("")
+	}
+	for _, rule := range p.Deny {
+		if matchPathPattern(rule.Pattern, cleaned) {
+			return fmt.Sprintf("matches the denied pattern %s", rule.Pattern), p.action(rule.Action)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return "", ""
+	}
+	for _, pattern := range p.Allow {
+		if matchPathPattern(pattern, cleaned) {
+			return "", ""
+		}
+	}
+	return "matches no allowed pattern", p.action("")
+}
+
+// applyPathPolicy checks the log paths against the path policy. It returns the log paths to annotate,
+// or an error when a log path has to be rejected.
+func applyPathPolicy(logPaths []logPath, policy PathPolicy) ([]logPath, error) {
+	accepted := make([]logPath, 0, len(logPaths))
+	for _, entry := range logPaths {
+		cleaned := path.Clean(entry.Path)
+		if policy.Normalize {
+			entry.Path = cleaned
+		}
+
+		reason, action := policy.violation(cleaned)
+		switch {
+		case reason == "":
+		case action == PathActionDrop:
+			continue
+		case action == PathActionWarn:
+			logger.WarnWith("log path violates the path policy").
+				String("path", entry.Path).String("container", entry.Container).String("reason", reason).Write()
+		default:
+			return nil, fmt.Errorf("log path %s of container %s %s", entry.Path, entry.Container, reason)
+		}
+		accepted = append(accepted, entry)
+	}
+	return accepted, nil
+}
+
+// matchPathPattern checks if a cleaned path matches a pattern. Patterns without glob characters
+// match the path itself and everything below it, the other ones have to match the whole path.
+func matchPathPattern(pattern, cleaned string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		prefix := path.Clean(pattern)
+		return cleaned == prefix || strings.HasPrefix(cleaned, strings.TrimSuffix(prefix, "/")+"/")
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(cleaned, "/"))
+}
+
+// matchSegments matches path segments against glob segments, where ** matches any number of segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == globAnySegments {
+		for skipped := 0; skipped <= len(segments); skipped++ {
+			if matchSegments(pattern[1:], segments[skipped:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], segments[0])
+	return err == nil && matched && matchSegments(pattern[1:], segments[1:])
+}
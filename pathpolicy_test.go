@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"/var/log", "/var/log", true},
+		{"/var/log", "/var/log/app.log", true},
+		{"/var/log/", "/var/log/nginx/access.log", true},
+		{"/var/log", "/var/logs/app.log", false},
+		{"/", "/etc/shadow", true},
+		{"/var/log/*.log", "/var/log/app.log", true},
+		{"/var/log/*.log", "/var/log/nginx/access.log", false},
+		{"/var/log/**/*.log", "/var/log/app.log", true},
+		{"/var/log/**/*.log", "/var/log/nginx/2024/access.log", true},
+		{"/var/log/**", "/var/log", true},
+		{"/etc/**", "/var/log/app.log", false},
+		{"/var/lib/*/pods/**", "/var/lib/kubelet/pods/uid/volumes", true},
+	}
+
+	for _, test := range tests {
+		if matched := matchPathPattern(test.pattern, test.path); matched != test.expected {
+			t.Errorf("Expected pattern %s matching %s to be %t", test.pattern, test.path, test.expected)
+		}
+	}
+}
+
+func TestApplyPathPolicy(t *testing.T) {
+	logPaths := []logPath{
+		{Container: "app", Path: "/var/log/app.log"},
+		{Container: "app", Path: "/var/log/../../etc/shadow"},
+		{Container: "app", Path: "logs/app.log"},
+		{Container: "app", Path: "/var/lib/kubelet/pods/uid/app.log"},
+	}
+
+	tests := []struct {
+		name          string
+		policy        PathPolicy
+		expectedPaths []string
+		expectedError string
+	}{
+		{
+			name: "no policy",
+			expectedPaths: []string{
+				"/var/log/app.log",
+				"/var/log/../../etc/shadow",
+				"logs/app.log",
+				"/var/lib/kubelet/pods/uid/app.log",
+			},
+		},
+		{
+			name:          "normalize",
+			policy:        PathPolicy{Normalize: true},
+			expectedPaths: []string{"/var/log/app.log", "/etc/shadow", "logs/app.log", "/var/lib/kubelet/pods/uid/app.log"},
+		},
+		{
+			name:          "require absolute",
+			policy:        PathPolicy{RequireAbsolute: true},
+			expectedError: "log path logs/app.log of container app is not absolute",
+		},
+		{
+			name:          "allowlist with drop",
+			policy:        PathPolicy{Allow: []string{"/var/log"}, Action: PathActionDrop},
+			expectedPaths: []string{"/var/log/app.log"},
+		},
+		{
+			name: "denylist with per rule actions",
+			policy: PathPolicy{
+				Deny: []PathRule{
+					{Pattern: "/var/lib/kubelet", Action: PathActionDrop},
+					{Pattern: "/etc/**", Action: PathActionWarn},
+				},
+			},
+			expectedPaths: []string{"/var/log/app.log", "/var/log/../../etc/shadow", "logs/app.log"},
+		},
+		{
+			name:          "denylist checked against the cleaned paths",
+			policy:        PathPolicy{Deny: []PathRule{{Pattern: "/etc"}}},
+			expectedError: "log path /var/log/../../etc/shadow of container app matches the denied pattern /etc",
+		},
+		{
+			name: "deny takes precedence over allow",
+			policy: PathPolicy{
+				Normalize: true,
+				Allow:     []string{"/"},
+				Deny:      []PathRule{{Pattern: "/etc/shadow", Action: PathActionDrop}},
+				Action:    PathActionDrop,
+			},
+			expectedPaths: []string{"/var/log/app.log", "/var/lib/kubelet/pods/uid/app.log"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			accepted, err := applyPathPolicy(logPaths, test.policy)
+			if test.expectedError != "" {
+				if err == nil || err.Error() != test.expectedError {
+					t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			paths := make([]string, 0, len(accepted))
+			for _, path := range accepted {
+				paths = append(paths, path.Path)
+			}
+			if !reflect.DeepEqual(paths, test.expectedPaths) {
+				t.Errorf("Expected paths %v, got %v", test.expectedPaths, paths)
+			}
+		})
+	}
+}
+
+func TestPathPolicyMutation(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PathPolicy:          PathPolicy{Deny: []PathRule{{Pattern: "/var/log/worker*.log", Action: PathActionDrop}}},
+	}
+	response := validateFixture(t, "test_data/pod-multiple-containers.json", settings)
+	assertMutation(t, response, map[string]string{"co_elastic_logs_path": "/var/log/app.log"})
+
+	settings.PathPolicy = PathPolicy{Allow: []string{"/var/log/app.log"}}
+	response = validateFixture(t, "test_data/pod-multiple-containers.json", settings)
+	assertRejection(t, response, "log path /var/log/worker.log of container worker matches no allowed pattern")
+
+	// Dropping every log path falls back to the no_match action
+	settings.PathPolicy = PathPolicy{Allow: []string{"/srv/logs"}, Action: PathActionDrop}
+	response = validateFixture(t, "test_data/pod-multiple-containers.json", settings)
+	assertMutation(t, response, map[string]string{LogEnabledAnnotation: LogEnabledValue})
+}
+
+func TestPathPolicySettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        PathPolicy
+		expectedError string
+	}{
+		{"unknown action", PathPolicy{Action: "ignore"}, "path_policy action must be one of reject, drop, warn"},
+		{"empty allow pattern", PathPolicy{Allow: []string{""}}, "path_policy allow patterns cannot be empty"},
+		{
+			"invalid deny pattern",
+			PathPolicy{Deny: []PathRule{{Pattern: "/var/log/[a-"}}},
+			"path_policy deny pattern \"/var/log/[a-\" is not valid: syntax error in pattern",
+		},
+		{
+			"unknown deny action",
+			PathPolicy{Deny: []PathRule{{Pattern: "/etc", Action: "skip"}}},
+			"path_policy deny action must be one of reject, drop, warn",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_%d",
+				PathPolicy:          test.policy,
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DelimiterComma splits log paths on commas.
+	DelimiterComma = "comma"
+	// DelimiterColon splits log paths on colons.
+	DelimiterColon = "colon"
+	// DelimiterSemicolon splits log paths on semicolons.
+	DelimiterSemicolon = "semicolon"
+	// DelimiterNewline splits log paths on line breaks.
+	DelimiterNewline = "newline"
+)
+
+// ValueParsing defines how the value of a matched environment variable is turned into log paths.
+type ValueParsing struct {
+	// Delimiters are the names of the separators used to split a value into several log paths:
+	// comma, colon, semicolon or newline. Defaults to comma.
+	Delimiters []string `json:"delimiters,omitempty"`
+	// KeepWhitespace disables the trimming of the whitespace surrounding each log path.
+	KeepWhitespace bool `json:"keep_whitespace,omitempty"`
+	// KeepEmpty keeps the empty log paths produced by consecutive or trailing delimiters.
+	KeepEmpty bool `json:"keep_empty,omitempty"`
+	// JSONArray accepts values written as a JSON array of strings, e.g. ["/a.log","/b.log"].
+	JSONArray bool `json:"json_array,omitempty"`
+}
+
+// delimiterSeparator returns the separator identified by a delimiter name.
+func delimiterSeparator(name string) (string, bool) {
+	switch name {
+	case DelimiterComma:
+		return ",", true
+	case DelimiterColon:
+		return ":", true
+	case DelimiterSemicolon:
+		return ";", true
+	case DelimiterNewline:
+		return "\n", true
+	default:
+		return "", false
+	}
+}
+
+// separators returns the configured separators, falling back to the default one.
+func (p *ValueParsing) separators() []string {
+	if len(p.Delimiters) == 0 {
+		return []string{","}
+	}
+
+	var separators []string
+	for _, name := range p.Delimiters {
+		if separator, ok := delimiterSeparator(name); ok {
+			separators = append(separators, separator)
+		}
+	}
+	return separators
+}
+
+// Valid validates the value parsing settings.
+func (p *ValueParsing) Valid() error {
+	for _, name := range p.Delimiters {
+		if _, ok := delimiterSeparator(name); !ok {
+			return fmt.Errorf("value_parsing delimiter %q is not one of %s, %s, %s, %s",
+				name, DelimiterComma, DelimiterColon, DelimiterSemicolon, DelimiterNewline)
+		}
+	}
+	return nil
+}
+
+// splitLogPaths splits the value of an environment variable into log paths.
+func splitLogPaths(value string, parsing ValueParsing) []string {
+	var segments []string
+	if parsing.JSONArray && strings.HasPrefix(strings.TrimSpace(value), "[") {
+		if err := json.Unmarshal([]byte(value), &segments); err != nil {
+			logger.Warn("cannot decode log paths as a JSON array, splitting them on delimiters")
+			segments = nil
+		}
+	}
+	if segments == nil {
+		segments = splitOnSeparators(value, parsing.separators())
+	}
+
+	paths := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		if !parsing.KeepWhitespace {
+			segment = strings.TrimSpace(segment)
+		}
+		if segment == "" && !parsing.KeepEmpty {
+			continue
+		}
+		paths = append(paths, segment)
+	}
+	return paths
+}
+
+// splitOnSeparators splits a string on any of the given separators.
+func splitOnSeparators(value string, separators []string) []string {
+	segments := []string{value}
+	for _, separator := range separators {
+		var split []string
+		for _, segment := range segments {
+			split = append(split, strings.Split(segment, separator)...)
+		}
+		segments = split
+	}
+	return segments
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitLogPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		parsing  ValueParsing
+		expected []string
+	}{
+		{"single path", "/var/log/app.log", ValueParsing{}, []string{"/var/log/app.log"}},
+		{"default comma", "/a.log, /b.log,,", ValueParsing{}, []string{"/a.log", "/b.log"}},
+		{"empty value", " ", ValueParsing{}, []string{}},
+		{
+			"multiple delimiters",
+			"/a.log;/b.log\n/c.log:/d.log",
+			ValueParsing{Delimiters: []string{DelimiterSemicolon, DelimiterNewline, DelimiterColon}},
+			[]string{"/a.log", "/b.log", "/c.log", "/d.log"},
+		},
+		{"colon not split by default", "/a.log:/b.log", ValueParsing{}, []string{"/a.log:/b.log"}},
+		{"keep whitespace", " /a.log ,/b.log", ValueParsing{KeepWhitespace: true}, []string{" /a.log ", "/b.log"}},
+		{"keep empty", "/a.log,,/b.log", ValueParsing{KeepEmpty: true}, []string{"/a.log", "", "/b.log"}},
+		{"json array", `["/a.log", " /b.log", ""]`, ValueParsing{JSONArray: true}, []string{"/a.log", "/b.log"}},
+		{"json array disabled", `["/a.log"]`, ValueParsing{}, []string{`["/a.log"]`}},
+		{"invalid json array", `[/a.log,/b.log]`, ValueParsing{JSONArray: true}, []string{"[/a.log", "/b.log]"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := splitLogPaths(test.value, test.parsing)
+			if strings.Join(paths, "|") != strings.Join(test.expected, "|") || len(paths) != len(test.expected) {
+				t.Errorf("Expected %q, got %q", test.expected, paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+func TestWorkloadPlacement(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		Placement:           PlacementWorkload,
+		CustomResources: []CustomResource{
+			{
+				Group:         "example.com",
+				Kind:          "WorkerPool",
+				TemplatePaths: []string{"/spec/pools/0/template", "/spec/pools/1/template"},
+			},
+		},
+	}
+
+	tests := []struct {
+		fixture             string
+		templatePath        []string
+		expectedAnnotations map[string]string
+	}{
+		{
+			"test_data/deployment-env.json",
+			[]string{"spec", "template"},
+			map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/err.log",
+			},
+		},
+		{
+			"test_data/workerpool-env.json",
+			[]string{"spec", "pools", "0", "template"},
+			map[string]string{
+				"co_elastic_logs_path":       "/var/log/small.log",
+				"co_elastic_logs_path_ext_1": "/var/log/large.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			response := validateFixture(t, test.fixture, settings)
+			assertMutation(t, response, test.expectedAnnotations)
+
+			template := mutatedMap(t, response, test.templatePath...)
+			if metadata, ok := template["metadata"].(map[string]interface{}); ok {
+				if _, found := metadata["annotations"]; found {
+					t.Errorf("Expected the pod template to be left untouched, got %v", metadata)
+				}
+			}
+		})
+	}
+}
+
+func TestPodPlacement(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		Placement:           PlacementPod,
+	}
+
+	response := validateFixture(t, "test_data/deployment-env.json", settings)
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+
+	request := podRequest(settings, nil, &corev1.EnvVar{Name: stringPtr("vestack_varlog"), Value: "/var/log/app.log"})
+	response, err := validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertMutation(t, response, map[string]string{"co_elastic_logs_path": "/var/log/app.log"})
+
+	// The configured owner kinds still restrict the eligible Pods
+	settings.PodOwnerKinds = []string{REPLICASET_KIND}
+	request.Settings = mustMarshalJSON(settings)
+	response, err = validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertNoMutation(t, response)
+}
+
+func TestPlacementSettings(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "log_path",
+		AnnotationExtFormat: "log_path_%d",
+		Placement:           "namespace",
+	}
+
+	valid, err := settings.Valid()
+	expectedError := "placement must be one of template, workload, pod"
+	if valid || err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// DefaultManagedKeysAnnotation is the default key of the annotation listing the annotations and labels
+// managed by the policy.
+const DefaultManagedKeysAnnotation = "log-env-to-annotation.kubewarden.io/managed-keys"
+
+// managedKeys is the value of the marker annotation listing the annotations and labels written by the policy.
+type managedKeys struct {
+	Annotations []string `json:"annotations,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+}
+
+// managedKeysAnnotation returns the key of the marker annotation.
+func (s *Settings) managedKeysAnnotation() string {
+	if s.ManagedKeysAnnotation == "" {
+		return DefaultManagedKeysAnnotation
+	}
+	return s.ManagedKeysAnnotation
+}
+
+// readManagedKeys reads the marker annotation of a metadata map.
+func readManagedKeys(metadata map[string]interface{}, markerKey string) (managedKeys, bool) {
+	var keys managedKeys
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	value, ok := annotations[markerKey].(string)
+	if !ok {
+		return keys, false
+	}
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		logger.WarnWith("ignoring invalid managed keys annotation").String("key", markerKey).Err("error", err).Write()
+		return keys, false
+	}
+	return keys, true
+}
+
+// previousManagedKeys returns the keys managed by the policy before this request. The marker annotation
+// of the object is used when present, the one of the old object otherwise.
+func previousManagedKeys(metadata, oldMetadata map[string]interface{}, markerKey string) managedKeys {
+	if keys, ok := readManagedKeys(metadata, markerKey); ok {
+		return keys
+	}
+	keys, _ := readManagedKeys(oldMetadata, markerKey)
+	return keys
+}
+
+// pruneStaleKeys removes from a metadata map the annotations and labels managed by the policy before
+// this request that are not generated anymore. It returns true when something was removed.
+func pruneStaleKeys(metadata map[string]interface{}, previous managedKeys, generated managedKeys) bool {
+	pruned := false
+	for _, field := range []struct {
+		name      string
+		previous  []string
+		generated []string
+	}{
+		{"annotations", previous.Annotations, generated.Annotations},
+		{"labels", previous.Labels, generated.Labels},
+	} {
+		existing, ok := metadata[field.name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stillGenerated := make(map[string]bool, len(field.generated))
+		for _, key := range field.generated {
+			stillGenerated[key] = true
+		}
+		for _, key := range field.previous {
+			if _, found := existing[key]; found && !stillGenerated[key] {
+				delete(existing, key)
+				pruned = true
+			}
+		}
+	}
+	return pruned
+}
+
+// pruneManagedKeys removes from a metadata map all the annotations and labels managed by the policy
+// before this request, along with the marker annotation, when nothing is generated anymore.
+// It returns true when something was removed.
+func pruneManagedKeys(metadata, oldMetadata map[string]interface{}, markerKey string) bool {
+	pruned := pruneStaleKeys(metadata, previousManagedKeys(metadata, oldMetadata, markerKey), managedKeys{})
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		if _, found := annotations[markerKey]; found {
+			delete(annotations, markerKey)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// ownedValues returns the values the policy owns among the ones it writes: the ones missing from
+// the existing values, and the ones it already managed. The values set beforehand by users stay
+// theirs, even when the policy overwrites them or writes the same value, so that they are never pruned.
+func ownedValues(values map[string]string, existing map[string]interface{}, previous []string) map[string]string {
+	owned := make(map[string]string, len(values))
+	for key, value := range values {
+		if _, found := existing[key]; !found || slices.Contains(previous, key) {
+			owned[key] = value
+		}
+	}
+	return owned
+}
+
+// managedKeysValue returns the value of the marker annotation listing the given annotations and labels.
+func managedKeysValue(annotations, labels map[string]string) string {
+	encoded, err := json.Marshal(managedKeys{Annotations: sortedKeys(annotations), Labels: sortedKeys(labels)})
+	if err != nil {
+		return "{}"
+	}
+	return string(encoded)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"testing"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+// deploymentUpdateRequest returns an UPDATE request for the Deployment fixture, whose pod template
+// and old pod template are given the provided annotations.
+func deploymentUpdateRequest(
+	t *testing.T,
+	annotations, oldAnnotations map[string]interface{},
+	settings Settings,
+) kubewarden_protocol.ValidationRequest {
+	t.Helper()
+
+	templateMetadata := []string{"spec", "template", "metadata"}
+	admissionRequest := loadFixture(t, "test_data/deployment-env.json",
+		withAnnotationsAt(t, templateMetadata, annotations))
+	admissionRequest.Operation = "UPDATE"
+	admissionRequest.OldObject = loadFixture(t, "test_data/deployment-env.json",
+		withAnnotationsAt(t, templateMetadata, oldAnnotations)).Object
+	return kubewarden_protocol.ValidationRequest{
+		Request:  admissionRequest,
+		Settings: mustMarshalJSON(settings),
+	}
+}
+
+func TestPruneStaleAnnotations(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PruneStale:          true,
+	}
+	previousMarker := `{"annotations":["co_elastic_logs_path","co_elastic_logs_path_ext_1",` +
+		`"co_elastic_logs_path_ext_2","co_elastic_logs_path_ext_3"]}`
+	previousAnnotations := map[string]interface{}{
+		"co_elastic_logs_path":        "/var/log/app.log",
+		"co_elastic_logs_path_ext_1":  "/var/log/err.log",
+		"co_elastic_logs_path_ext_2":  "/var/log/audit.log",
+		"co_elastic_logs_path_ext_3":  "/var/log/slow.log",
+		"co_elastic_logs_path_ext_4":  "/var/log/user.log",
+		"example.com/owner":           "team-a",
+		DefaultManagedKeysAnnotation:  previousMarker,
+		"kubectl.kubernetes.io/notes": "kept",
+	}
+	expectedAnnotations := map[string]interface{}{
+		"co_elastic_logs_path":        "/var/log/app.log",
+		"co_elastic_logs_path_ext_1":  "/var/log/err.log",
+		"co_elastic_logs_path_ext_4":  "/var/log/user.log",
+		"example.com/owner":           "team-a",
+		"kubectl.kubernetes.io/notes": "kept",
+		DefaultManagedKeysAnnotation:  `{"annotations":["co_elastic_logs_path","co_elastic_logs_path_ext_1"]}`,
+	}
+
+	withoutMarker := make(map[string]interface{})
+	for key, value := range previousAnnotations {
+		if key != DefaultManagedKeysAnnotation {
+			withoutMarker[key] = value
+		}
+	}
+
+	tests := []struct {
+		name           string
+		annotations    map[string]interface{}
+		oldAnnotations map[string]interface{}
+	}{
+		{"marker on the object", previousAnnotations, nil},
+		{"marker on the old object", withoutMarker, previousAnnotations},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := validateTest(t, deploymentUpdateRequest(t, test.annotations, test.oldAnnotations, settings))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+			if len(annotations) != len(expectedAnnotations) {
+				t.Errorf("Expected annotations %v, got %v", expectedAnnotations, annotations)
+			}
+			for key, expectedValue := range expectedAnnotations {
+				if annotations[key] != expectedValue {
+					t.Errorf("Expected annotation %s to be %v, got %v", key, expectedValue, annotations[key])
+				}
+			}
+		})
+	}
+}
+
+func TestPruneStaleDisabled(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+	}
+	existing := map[string]interface{}{
+		"co_elastic_logs_path_ext_2": "/var/log/audit.log",
+		DefaultManagedKeysAnnotation: `{"annotations":["co_elastic_logs_path_ext_2"]}`,
+	}
+
+	response, err := validateTest(t, deploymentUpdateRequest(t, existing, existing, settings))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+	if annotations["co_elastic_logs_path_ext_2"] != "/var/log/audit.log" {
+		t.Errorf("Expected annotations not to be pruned, got %v", annotations)
+	}
+}
+
+func TestPruneStaleLabels(t *testing.T) {
+	metadata := map[string]interface{}{
+		"labels": map[string]interface{}{
+			"co_elastic_logs_path":       "var_log_app.log",
+			"co_elastic_logs_path_ext_1": "var_log_err.log",
+			"app":                        "nginx",
+		},
+	}
+	previous := managedKeys{Labels: []string{"co_elastic_logs_path", "co_elastic_logs_path_ext_1"}}
+	generated := managedKeys{Labels: []string{"co_elastic_logs_path"}}
+
+	if !pruneStaleKeys(metadata, previous, generated) {
+		t.Error("Expected stale labels to be pruned")
+	}
+	labels := metadata["labels"].(map[string]interface{})
+	if _, found := labels["co_elastic_logs_path_ext_1"]; found || len(labels) != 2 {
+		t.Errorf("Unexpected labels after pruning: %v", labels)
+	}
+
+	if pruneStaleKeys(metadata, previous, generated) {
+		t.Error("Expected nothing to be pruned twice")
+	}
+}
+
+func TestPruneStaleWithoutLogPaths(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		NoMatch:             NoMatch{Action: NoMatchIgnore},
+		PruneStale:          true,
+	}
+	previousAnnotations := map[string]interface{}{
+		"co_elastic_logs_path":       "/var/log/app.log",
+		"example.com/owner":          "team-a",
+		DefaultManagedKeysAnnotation: `{"annotations":["co_elastic_logs_path"]}`,
+	}
+
+	// The container declaring the log path was removed from the Pod
+	admissionRequest := loadFixture(t, "test_data/pod-no-env.json",
+		withAnnotationsAt(t, []string{"metadata"}, previousAnnotations))
+	admissionRequest.Operation = "UPDATE"
+	admissionRequest.OldObject = admissionRequest.Object
+	response, err := validateTest(t, kubewarden_protocol.ValidationRequest{
+		Request:  admissionRequest,
+		Settings: mustMarshalJSON(settings),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	annotations := mutatedMap(t, response, "metadata", "annotations")
+	if len(annotations) != 1 || annotations["example.com/owner"] != "team-a" {
+		t.Errorf("Expected only example.com/owner to be kept, got %v", annotations)
+	}
+
+	// Without a previous marker, the Pod is left unchanged
+	admissionRequest = loadFixture(t, "test_data/pod-no-env.json", nil)
+	admissionRequest.Operation = "UPDATE"
+	response, err = validateTest(t, kubewarden_protocol.ValidationRequest{
+		Request:  admissionRequest,
+		Settings: mustMarshalJSON(settings),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertNoMutation(t, response)
+}
+
+func TestPruneStaleKeepsUserAnnotations(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PruneStale:          true,
+	}
+	// Set by hand before the policy was deployed, then overwritten by it
+	existing := map[string]interface{}{
+		"co_elastic_logs_path_ext_1": "/var/log/custom.log",
+	}
+
+	response, err := validateTest(t, deploymentUpdateRequest(t, existing, existing, settings))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+	if annotations[DefaultManagedKeysAnnotation] != `{"annotations":["co_elastic_logs_path"]}` {
+		t.Errorf("Expected only the created annotation to be managed, got %v", annotations)
+	}
+
+	// The overwritten annotation is not pruned once it is not generated anymore
+	settings.PathSelection = PathSelection{MaxPaths: 1}
+	response, err = validateTest(t, deploymentUpdateRequest(t, annotations, annotations, settings))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+const (
+	// OrderDeclaration keeps the log paths in the order of the containers and of their environment.
+	OrderDeclaration = "declaration"
+	// OrderLexical sorts the log paths alphabetically.
+	OrderLexical = "lexical"
+	// OrderContainer sorts the log paths by container name, keeping the declaration order of each container.
+	OrderContainer = "container"
+	// OverflowTruncate keeps the first max_paths log paths.
+	OverflowTruncate = "truncate"
+	// OverflowReject rejects the request when there are more than max_paths log paths.
+	OverflowReject = "reject"
+)
+
+// PathSelection defines how the discovered log paths are deduplicated, ordered and limited
+// before being numbered.
+type PathSelection struct {
+	// Dedupe keeps only the first occurrence of the log paths that are equal once cleaned.
+	Dedupe bool `json:"dedupe,omitempty"`
+	// Order is either declaration (default), lexical or container.
+	Order string `json:"order,omitempty"`
+	// MaxPaths is the maximum number of log paths. Zero means no limit.
+	MaxPaths int `json:"max_paths,omitempty"`
+	// Overflow defines what happens when there are more than MaxPaths log paths: truncate (default) or reject.
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// Valid validates the path selection settings.
+func (p *PathSelection) Valid() error {
+	switch p.Order {
+	case "", OrderDeclaration, OrderLexical, OrderContainer:
+	default:
+		return fmt.Errorf("path_selection order must be one of %s, %s, %s", OrderDeclaration, OrderLexical, OrderContainer)
+	}
+	if p.MaxPaths < 0 {
+		return errors.New("path_selection max_paths cannot be negative")
+	}
+	switch p.Overflow {
+	case "", OverflowTruncate, OverflowReject:
+	default:
+		return fmt.Errorf("path_selection overflow must be either %s or %s", OverflowTruncate, OverflowReject)
+	}
+	return nil
+}
+
+// selectLogPaths deduplicates, orders and limits the log paths according to the path selection.
+// It returns an error when there are too many log paths and the overflow is reject.
+func selectLogPaths(logPaths []logPath, selection PathSelection) ([]logPath, error) {
+	selected := make([]logPath, 0, len(logPaths))
+	seen := make(map[string]bool, len(logPaths))
+	for _, entry := range logPaths {
+		if selection.Dedupe {
+			cleaned := path.Clean(entry.Path)
+			if seen[cleaned] {
+				continue
+			}
+			seen[cleaned] = true
+		}
+		selected = append(selected, entry)
+	}
+
+	switch selection.Order {
+	case OrderLexical:
+		sort.SliceStable(selected, func(i, j int) bool { return selected[i].Path < selected[j].Path })
+	case OrderContainer:
+		sort.SliceStable(selected, func(i, j int) bool { return selected[i].Container < selected[j].Container })
+	}
+
+	if selection.MaxPaths > 0 && len(selected) > selection.MaxPaths {
+		if selection.Overflow == OverflowReject {
+			return nil, fmt.Errorf("found %d log paths, more than the %d allowed by path_selection max_paths",
+				len(selected), selection.MaxPaths)
+		}
+		selected = selected[:selection.MaxPaths]
+	}
+	return selected, nil
+}
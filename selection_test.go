@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+)
+
+func TestSelectLogPaths(t *testing.T) {
+	logPaths := []logPath{
+		{Container: "worker", Path: "/var/log/worker.log"},
+		{Container: "worker", Path: "/var/log/app.log"},
+		{Container: "app", Path: "/var/log/./app.log"},
+		{Container: "app", Path: "/var/log/access.log"},
+	}
+
+	tests := []struct {
+		name          string
+		selection     PathSelection
+		expectedPaths []string
+		expectedError string
+	}{
+		{
+			name:          "declaration order",
+			expectedPaths: []string{"/var/log/worker.log", "/var/log/app.log", "/var/log/./app.log", "/var/log/access.log"},
+		},
+		{
+			name:          "dedupe",
+			selection:     PathSelection{Dedupe: true},
+			expectedPaths: []string{"/var/log/worker.log", "/var/log/app.log", "/var/log/access.log"},
+		},
+		{
+			name:          "lexical order",
+			selection:     PathSelection{Dedupe: true, Order: OrderLexical},
+			expectedPaths: []string{"/var/log/access.log", "/var/log/app.log", "/var/log/worker.log"},
+		},
+		{
+			name:          "container order",
+			selection:     PathSelection{Order: OrderContainer},
+			expectedPaths: []string{"/var/log/./app.log", "/var/log/access.log", "/var/log/worker.log", "/var/log/app.log"},
+		},
+		{
+			name:          "truncate",
+			selection:     PathSelection{Order: OrderLexical, MaxPaths: 2},
+			expectedPaths: []string{"/var/log/./app.log", "/var/log/access.log"},
+		},
+		{
+			name:          "reject",
+			selection:     PathSelection{Dedupe: true, MaxPaths: 2, Overflow: OverflowReject},
+			expectedError: "found 3 log paths, more than the 2 allowed by path_selection max_paths",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := selectLogPaths(logPaths, test.selection)
+			if test.expectedError != "" {
+				if err == nil || err.Error() != test.expectedError {
+					t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			paths := make([]string, 0, len(selected))
+			for _, path := range selected {
+				paths = append(paths, path.Path)
+			}
+			if !reflect.DeepEqual(paths, test.expectedPaths) {
+				t.Errorf("Expected paths %v, got %v", test.expectedPaths, paths)
+			}
+		})
+	}
+}
+
+func TestPathSelectionMutation(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PodOwnerKinds:       []string{"none"},
+		PathSelection:       PathSelection{Dedupe: true, Order: OrderLexical},
+	}
+	withSidecar := func(pod *corev1.Pod) {
+		pod.Spec.Containers = append(pod.Spec.Containers, &corev1.Container{
+			Name: stringPtr("sidecar"),
+			Env:  []*corev1.EnvVar{{Name: stringPtr("LOG_PATH"), Value: "/var/log/shared.log"}},
+		})
+	}
+	request := podRequest(settings, withSidecar,
+		&corev1.EnvVar{Name: stringPtr("LOG_PATH"), Value: "/var/log/shared.log,/var/log/app.log"})
+
+	response, err := validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertMutation(t, response, map[string]string{
+		"co_elastic_logs_path":       "/var/log/app.log",
+		"co_elastic_logs_path_ext_1": "/var/log/shared.log",
+	})
+
+	settings.PathSelection = PathSelection{MaxPaths: 2, Overflow: OverflowReject}
+	request.Settings = mustMarshalJSON(settings)
+	response, err = validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertRejection(t, response, "found 3 log paths, more than the 2 allowed by path_selection max_paths")
+}
+
+func TestPathSelectionSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		selection     PathSelection
+		expectedError string
+	}{
+		{
+			"unknown order",
+			PathSelection{Order: "random"},
+			"path_selection order must be one of declaration, lexical, container",
+		},
+		{"negative max paths", PathSelection{MaxPaths: -1}, "path_selection max_paths cannot be negative"},
+		{"unknown overflow", PathSelection{Overflow: "drop"}, "path_selection overflow must be either truncate or reject"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "LOG_PATH",
+				AnnotationBase:      "log_path",
+				AnnotationExtFormat: "log_path_%d",
+				PathSelection:       test.selection,
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
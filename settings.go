@@ -1,32 +1,191 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
 
 	kubewarden "github.com/kubewarden/policy-sdk-go"
 	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
 )
 
+const (
+	// PlacementTemplate annotates the pod templates of the workloads.
+	PlacementTemplate = "template"
+	// PlacementWorkload annotates the top-level metadata of the workloads.
+	PlacementWorkload = "workload"
+	// PlacementPod leaves the workloads untouched and annotates their Pods when they are created.
+	PlacementPod = "pod"
+	// ContainerStrategyFlat numbers the log paths of all the containers in a single list.
+	ContainerStrategyFlat = "flat"
+	// ContainerStrategyPerContainer numbers the log paths of each container separately.
+	ContainerStrategyPerContainer = "per_container"
+	// ContainerPlaceholder is replaced by the container name inside of per-container annotation keys.
+	ContainerPlaceholder = "{container}"
+)
+
 // Settings defines all the configurable options of the policy.
 type Settings struct {
 	// EnvKey is the container environment variable name to match for conversion.
 	EnvKey string `json:"env_key"`
+	// EnvKeyMatch defines how EnvKey is matched against the environment variable names:
+	// exact (default), prefix, glob or regex.
+	EnvKeyMatch string `json:"env_key_match,omitempty"`
+	// EnvKeyCaseInsensitive makes the matching of the environment variable names case insensitive.
+	EnvKeyCaseInsensitive bool `json:"env_key_case_insensitive,omitempty"`
 	// AnnotationBase is the base annotation key for the first log path.
+	// It is not needed when output_mode is list.
 	AnnotationBase string `json:"annotation_base"`
 	// AnnotationExtFormat is the extended annotation key format for subsequent log paths.
 	// Format: co_elastic_logs_path_ext_%d, where %d is replaced by the sequence number 1, 2, 3...
 	AnnotationExtFormat string `json:"annotation_ext_format"`
+	// ExtKeyMode defines what the extended annotation keys derive from: "index" (default) numbers them
+	// by position with %d, "env_suffix", "path_slug" and "hash" replace %s with an identifier of the
+	// log path, so that the keys do not shift when the environment variables are reordered.
+	ExtKeyMode string `json:"ext_key_mode,omitempty"`
+	// BaseEnv is the environment variable whose log path goes to annotation_base with the stable
+	// ext_key_mode. By default, it is the one matching env_key without a suffix.
+	BaseEnv string `json:"base_env,omitempty"`
+	// OutputMode defines where the log paths are written: "numbered" (default) uses annotation_base
+	// and annotation_ext_format, "list" writes all of them to the list_annotation, "both" does both.
+	OutputMode string `json:"output_mode,omitempty"`
+	// ListAnnotation configures the annotation holding all the log paths in list and both output modes.
+	ListAnnotation *ListAnnotation `json:"list_annotation,omitempty"`
+	// TemplateMode defines how annotation_base, annotation_ext_format and the string values of
+	// additional_annotations are interpreted: "format" (default) uses fmt verbs and {name}
+	// placeholders, "go_template" renders them as Go text/template templates.
+	TemplateMode string `json:"template_mode,omitempty"`
+	// ContainerStrategy defines how the log paths of different containers are combined:
+	// "flat" (default) numbers all of them in a single list, "per_container" uses
+	// annotation keys where the {container} placeholder is replaced by the container name.
+	ContainerStrategy string `json:"container_strategy,omitempty"`
+	// IncludeInitContainers enables the discovery of log paths in init containers.
+	IncludeInitContainers bool `json:"include_init_containers,omitempty"`
+	// ContainerInclude restricts the discovery of log paths to the containers matching one of
+	// these regular expressions. The whole container name has to match.
+	ContainerInclude []string `json:"container_include,omitempty"`
+	// ContainerExclude skips the containers matching one of these regular expressions.
+	// The whole container name has to match.
+	ContainerExclude []string `json:"container_exclude,omitempty"`
+	// ValueParsing defines how the value of a matched environment variable is split into log paths.
+	ValueParsing ValueParsing `json:"value_parsing"`
+	// PathPolicy restricts the log paths that can be written to the annotations.
+	PathPolicy PathPolicy `json:"path_policy"`
+	// PathSelection defines how the log paths are deduplicated, ordered and limited before being numbered.
+	PathSelection PathSelection `json:"path_selection"`
+	// Lookups configures the context-aware resolution of values defined in other resources.
+	Lookups Lookups `json:"lookups"`
 	// AdditionalAnnotations are custom key-value pairs for annotations.
 	AdditionalAnnotations map[string]interface{} `json:"additional_annotations,omitempty"`
+	// ConditionalAnnotations are groups of custom annotations only added to the objects meeting
+	// their conditions, after the additional_annotations.
+	ConditionalAnnotations []ConditionalAnnotations `json:"conditional_annotations,omitempty"`
+	// ValueEncodings defines how the values of the additional and conditional annotations are
+	// encoded, per annotation key: "raw" (default) writes objects and arrays as compact JSON and
+	// the other values as they are, "json", "yaml" and "base64" encode the whole value.
+	ValueEncodings map[string]string `json:"value_encodings,omitempty"`
+	// NoMatch configures what happens when no container declares a log path.
+	NoMatch NoMatch `json:"no_match"`
+	// Targets defines whether each output is written to the annotations, to the labels or to both.
+	Targets Targets `json:"targets"`
+	// ConflictStrategy defines what happens when a generated annotation or label already exists on the
+	// object with a different value: "overwrite" (default), "keep", "fail" or "merge".
+	ConflictStrategy string `json:"conflict_strategy,omitempty"`
+	// ConflictStrategies overrides ConflictStrategy for specific annotation or label keys.
+	ConflictStrategies map[string]string `json:"conflict_strategies,omitempty"`
+	// PruneStale removes the annotations and labels generated for a previous version of the object
+	// that are not generated anymore. The generated keys are listed in the managed keys annotation.
+	PruneStale bool `json:"prune_stale,omitempty"`
+	// ManagedKeysAnnotation is the key of the annotation listing the annotations and labels
+	// managed by the policy. Defaults to DefaultManagedKeysAnnotation.
+	ManagedKeysAnnotation string `json:"managed_keys_annotation,omitempty"`
+	// InvalidAnnotations defines what happens when a generated annotation or label key is not a valid
+	// qualified name or the annotations exceed the size limit of the API server:
+	// "reject" (default) rejects the request, "skip" drops the offending annotations.
+	InvalidAnnotations string `json:"invalid_annotations,omitempty"`
+	// PodOwnerKinds lists the owner kinds that make a Pod eligible for mutation.
+	// The special value "none" matches Pods without owner references.
+	// Defaults to ReplicaSet, which selects the Pods created by Deployments.
+	PodOwnerKinds []string `json:"pod_owner_kinds,omitempty"`
+	// Placement defines where the annotations of the workloads are written: "template" (default)
+	// annotates their pod templates, "workload" their top-level metadata, and "pod" leaves them
+	// untouched, annotating their Pods at admission instead, so that enabling the policy does not
+	// trigger rollouts.
+	Placement string `json:"placement,omitempty"`
+	// AutoMount adds volume mounts for the log directories that are not on a volume.
+	AutoMount AutoMount `json:"auto_mount"`
+	// CustomResources describes where the pod templates of custom resources are located.
+	CustomResources []CustomResource `json:"custom_resources,omitempty"`
+}
+
+// CustomResource maps a group/version/kind to the locations of its pod templates.
+type CustomResource struct {
+	// Group is the API group of the resource, empty for the core group.
+	Group string `json:"group"`
+	// Version is the API version of the resource. An empty version matches all of them.
+	Version string `json:"version,omitempty"`
+	// Kind is the kind of the resource.
+	Kind string `json:"kind"`
+	// TemplatePaths are JSON pointers to objects shaped like a pod template,
+	// holding the template metadata and a pod spec with the containers.
+	// Example: /spec/template.
+	TemplatePaths []string `json:"template_paths"`
+}
+
+// matches checks if the custom resource describes the given group/version/kind.
+func (r *CustomResource) matches(kind kubewarden_protocol.GroupVersionKind) bool {
+	return r.Group == kind.Group &&
+		(r.Version == "" || r.Version == kind.Version) &&
+		strings.EqualFold(r.Kind, kind.Kind)
+}
+
+// Valid validates the custom resource definition.
+func (r *CustomResource) Valid() error {
+	if r.Kind == "" {
+		return errors.New("custom_resources kind cannot be empty")
+	}
+	if len(r.TemplatePaths) == 0 {
+		return fmt.Errorf("custom_resources entry for kind %s must define template_paths", r.Kind)
+	}
+	for _, pointer := range r.TemplatePaths {
+		if _, err := parseJSONPointer(pointer); err != nil {
+			return fmt.Errorf("custom_resources entry for kind %s has invalid template path %q: %w",
+				r.Kind, pointer, err)
+		}
+	}
+	return nil
+}
+
+// ownerKinds returns the configured Pod owner kinds, falling back to the default ones.
+func (s *Settings) ownerKinds() []string {
+	if len(s.PodOwnerKinds) == 0 {
+		return []string{REPLICASET_KIND}
+	}
+	return s.PodOwnerKinds
+}
+
+// allPodsEligible checks if every Pod is eligible for mutation, which is the case when the
+// placement is pod and no pod_owner_kinds are configured.
+func (s *Settings) allPodsEligible() bool {
+	return s.Placement == PlacementPod && len(s.PodOwnerKinds) == 0
 }
 
 // NewSettingsFromValidationReq extracts settings from a ValidationRequest.
 func NewSettingsFromValidationReq(validationReq *kubewarden_protocol.ValidationRequest) (Settings, error) {
+	return decodeSettings(validationReq.Settings)
+}
+
+// decodeSettings decodes the settings payload. Numbers are kept as json.Number, so that the
+// integers of the additional annotations are written without losing precision.
+func decodeSettings(payload []byte) (Settings, error) {
 	settings := Settings{}
-	err := json.Unmarshal(validationReq.Settings, &settings)
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	err := decoder.Decode(&settings)
 	return settings, err
 }
 
@@ -35,42 +194,227 @@ func (s *Settings) Valid() (bool, error) {
 	if s.EnvKey == "" {
 		return false, errors.New("env_key cannot be empty")
 	}
-	if s.AnnotationBase == "" {
-		return false, errors.New("annotation_base cannot be empty")
+	if s.numberedOutput() {
+		if s.AnnotationBase == "" {
+			return false, errors.New("annotation_base cannot be empty")
+		}
+		if s.AnnotationExtFormat == "" {
+			return false, errors.New("annotation_ext_format cannot be empty")
+		}
 	}
-	if s.AnnotationExtFormat == "" {
-		return false, errors.New("annotation_ext_format cannot be empty")
+
+	if err := s.validCustomAnnotations(); err != nil {
+		return false, err
 	}
 
-	// Validate AdditionalAnnotations key-value pairs
-	if s.AdditionalAnnotations != nil {
-		for key, value := range s.AdditionalAnnotations {
-			if key == "" {
-				return false, errors.New("additional_annotations keys cannot be empty")
-			}
-			// Allow boolean, numeric, and other non-string types
-			// Only check for emptiness if the value is a string
-			if strVal, ok := value.(string); ok {
-				if strVal == "" {
-					return false, errors.New("additional_annotations string values cannot be empty")
-				}
+	for _, kind := range s.PodOwnerKinds {
+		if strings.TrimSpace(kind) == "" {
+			return false, errors.New("pod_owner_kinds entries cannot be empty")
+		}
+	}
+
+	for _, patterns := range [][]string{s.ContainerInclude, s.ContainerExclude} {
+		for _, pattern := range patterns {
+			if _, err := containerNamePattern(pattern); err != nil {
+				return false, fmt.Errorf("invalid container pattern %q: %w", pattern, err)
 			}
 		}
 	}
 
-	// Validate that AnnotationExtFormat contains the %d placeholder
-	if !strings.Contains(s.AnnotationExtFormat, "%d") {
-		return false, errors.New("annotation_ext_format must contain %d placeholder")
+	if err := s.ValueParsing.Valid(); err != nil {
+		return false, err
+	}
+
+	if err := s.PathPolicy.Valid(); err != nil {
+		return false, err
+	}
+
+	if err := s.PathSelection.Valid(); err != nil {
+		return false, err
+	}
+
+	if err := s.Lookups.Valid(); err != nil {
+		return false, err
+	}
+
+	if err := s.validMetadataUpdates(); err != nil {
+		return false, err
+	}
+
+	if err := s.AutoMount.Valid(); err != nil {
+		return false, err
+	}
+
+	for i := range s.CustomResources {
+		if err := s.CustomResources[i].Valid(); err != nil {
+			return false, err
+		}
+	}
+
+	if err := s.validOutputMode(); err != nil {
+		return false, err
+	}
+
+	if err := s.validAnnotationKeys(); err != nil {
+		return false, err
 	}
 	return true, nil
 }
 
+// validCustomAnnotations validates the additional and conditional annotations and their value encodings.
+func (s *Settings) validCustomAnnotations() error {
+	// Validate AdditionalAnnotations key-value pairs
+	if err := validAdditionalValues("additional_annotations", s.AdditionalAnnotations); err != nil {
+		return err
+	}
+	for i := range s.ConditionalAnnotations {
+		if err := s.ConditionalAnnotations[i].Valid(i); err != nil {
+			return err
+		}
+	}
+
+	for key, encoding := range s.ValueEncodings {
+		if err := validEncoding(key, encoding); err != nil {
+			return err
+		}
+		if !s.customAnnotationKey(key) {
+			return fmt.Errorf("value_encodings %s is not a key of additional_annotations or conditional_annotations", key)
+		}
+	}
+	return nil
+}
+
+// customAnnotationKey checks if a key is one of the additional or conditional annotations.
+func (s *Settings) customAnnotationKey(key string) bool {
+	if _, found := s.AdditionalAnnotations[key]; found {
+		return true
+	}
+	for _, group := range s.ConditionalAnnotations {
+		if _, found := group.Annotations[key]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// validMetadataUpdates validates the settings defining how the metadata of the objects is updated.
+func (s *Settings) validMetadataUpdates() error {
+	switch s.Placement {
+	case "", PlacementTemplate, PlacementWorkload, PlacementPod:
+	default:
+		return fmt.Errorf("placement must be one of %s, %s, %s", PlacementTemplate, PlacementWorkload, PlacementPod)
+	}
+
+	if err := s.NoMatch.Valid(); err != nil {
+		return err
+	}
+
+	if err := s.Targets.Valid(); err != nil {
+		return err
+	}
+
+	if err := validateAnnotationKey(s.managedKeysAnnotation()); err != nil {
+		return fmt.Errorf("managed_keys_annotation: %w", err)
+	}
+
+	if err := validConflictStrategy("conflict_strategy", s.ConflictStrategy); err != nil {
+		return err
+	}
+	for key, strategy := range s.ConflictStrategies {
+		if err := validConflictStrategy("conflict_strategies "+key, strategy); err != nil {
+			return err
+		}
+	}
+
+	switch s.InvalidAnnotations {
+	case "", InvalidAnnotationsReject, InvalidAnnotationsSkip:
+	default:
+		return fmt.Errorf("invalid_annotations must be either %s or %s",
+			InvalidAnnotationsReject, InvalidAnnotationsSkip)
+	}
+	return nil
+}
+
+// validAnnotationKeys validates the annotation keys according to the template mode and the container strategy.
+func (s *Settings) validAnnotationKeys() error {
+	matcher, err := newEnvKeyMatcher(*s)
+	if err != nil {
+		return err
+	}
+
+	switch s.ContainerStrategy {
+	case "", ContainerStrategyFlat, ContainerStrategyPerContainer:
+	default:
+		return fmt.Errorf("container_strategy must be either %s or %s",
+			ContainerStrategyFlat, ContainerStrategyPerContainer)
+	}
+
+	if err = s.validExtKeyMode(); err != nil {
+		return err
+	}
+
+	switch s.TemplateMode {
+	case "", TemplateModeFormat:
+	case TemplateModeGoTemplate:
+		return validateTemplates(*s, matcher.captureGroups())
+	default:
+		return fmt.Errorf("template_mode must be either %s or %s", TemplateModeFormat, TemplateModeGoTemplate)
+	}
+
+	if !s.numberedOutput() {
+		// The annotation keys are not used
+		return nil
+	}
+
+	// Validate that AnnotationExtFormat contains the %d placeholder
+	if !s.stableExtKeys() && !strings.Contains(s.AnnotationExtFormat, "%d") {
+		return errors.New("annotation_ext_format must contain %d placeholder")
+	}
+
+	if err = validateKeyPlaceholders(s.AnnotationBase, matcher.captureGroups()); err != nil {
+		return fmt.Errorf("annotation_base: %w", err)
+	}
+	if err = validateKeyPlaceholders(s.AnnotationExtFormat, matcher.captureGroups()); err != nil {
+		return fmt.Errorf("annotation_ext_format: %w", err)
+	}
+
+	for _, key := range sampleAnnotationKeys(*s, matcher.captureGroups()) {
+		if err = validateAnnotationKey(key); err != nil {
+			return err
+		}
+	}
+
+	if s.ContainerStrategy == ContainerStrategyPerContainer &&
+		(!strings.Contains(s.AnnotationBase, ContainerPlaceholder) ||
+			!strings.Contains(s.AnnotationExtFormat, ContainerPlaceholder)) {
+		return errors.New("annotation_base and annotation_ext_format must contain " +
+			ContainerPlaceholder + " placeholder when container_strategy is " + ContainerStrategyPerContainer)
+	}
+	return nil
+}
+
+//nolint:gochecknoglobals // Read-only pattern of the annotation key placeholders.
+var keyPlaceholderPattern = regexp.MustCompile(`\{[A-Za-z0-9_]+\}`)
+
+// validateKeyPlaceholders checks that the {name} placeholders of an annotation key refer
+// to the container name or to one of the capture groups of env_key.
+func validateKeyPlaceholders(key string, captureGroups []string) error {
+	for _, placeholder := range keyPlaceholderPattern.FindAllString(key, -1) {
+		if placeholder == ContainerPlaceholder {
+			continue
+		}
+		if !slices.Contains(captureGroups, placeholder[1:len(placeholder)-1]) {
+			return fmt.Errorf("placeholder %s is not a capture group of env_key", placeholder)
+		}
+	}
+	return nil
+}
+
 // validateSettings is called by Kubewarden when the policy is loaded.
 func validateSettings(payload []byte) ([]byte, error) {
 	logger.Info("validating settings")
 
-	settings := Settings{}
-	err := json.Unmarshal(payload, &settings)
+	settings, err := decodeSettings(payload)
 	if err != nil {
 		return kubewarden.RejectSettings(kubewarden.Message(fmt.Sprintf("Provided settings are not valid: %v", err)))
 	}
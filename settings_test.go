@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
@@ -157,6 +158,227 @@ func TestInvalidSettingsAnnotationExtFormatMissingPlaceholder(t *testing.T) {
 	}
 }
 
+func TestInvalidSettingsEmptyPodOwnerKind(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "test_env",
+		AnnotationBase:      "test_base",
+		AnnotationExtFormat: "test_ext_%d",
+		PodOwnerKinds:       []string{"StatefulSet", " "},
+	}
+
+	valid, err := settings.Valid()
+	if valid {
+		t.Errorf("Expected settings to be invalid due to empty entry in PodOwnerKinds")
+	}
+	if err == nil || err.Error() != "pod_owner_kinds entries cannot be empty" {
+		t.Errorf("Expected error 'pod_owner_kinds entries cannot be empty', got: %v", err)
+	}
+}
+
+func TestInvalidSettingsCustomResources(t *testing.T) {
+	tests := []struct {
+		name           string
+		customResource CustomResource
+		expectedError  string
+	}{
+		{
+			name:           "empty kind",
+			customResource: CustomResource{Group: "argoproj.io", TemplatePaths: []string{"/spec/template"}},
+			expectedError:  "custom_resources kind cannot be empty",
+		},
+		{
+			name:           "no template paths",
+			customResource: CustomResource{Group: "argoproj.io", Kind: "Rollout"},
+			expectedError:  "custom_resources entry for kind Rollout must define template_paths",
+		},
+		{
+			name: "invalid template path",
+			customResource: CustomResource{
+				Group:         "argoproj.io",
+				Kind:          "Rollout",
+				TemplatePaths: []string{"spec/template"},
+			},
+			expectedError: "custom_resources entry for kind Rollout has invalid template path \"spec/template\": " +
+				"JSON pointer must start with '/'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "test_env",
+				AnnotationBase:      "test_base",
+				AnnotationExtFormat: "test_ext_%d",
+				CustomResources:     []CustomResource{test.customResource},
+			}
+
+			valid, err := settings.Valid()
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestContainerStrategySettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      string
+		base          string
+		extFormat     string
+		expectedError string
+	}{
+		{"flat", ContainerStrategyFlat, "test_base", "test_ext_%d", ""},
+		{"per container", ContainerStrategyPerContainer, "test_{container}", "test_{container}_ext_%d", ""},
+		{
+			"per container without placeholder", ContainerStrategyPerContainer, "test_base", "test_{container}_ext_%d",
+			"annotation_base and annotation_ext_format must contain {container} placeholder " +
+				"when container_strategy is per_container",
+		},
+		{"unknown", "nested", "test_base", "test_ext_%d", "container_strategy must be either flat or per_container"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              "test_env",
+				AnnotationBase:      test.base,
+				AnnotationExtFormat: test.extFormat,
+				ContainerStrategy:   test.strategy,
+			}
+
+			valid, err := settings.Valid()
+			if test.expectedError == "" {
+				if !valid {
+					t.Errorf("Expected settings to be valid, got error: %v", err)
+				}
+				return
+			}
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestInvalidSettingsContainerPattern(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "test_env",
+		AnnotationBase:      "test_base",
+		AnnotationExtFormat: "test_ext_%d",
+		ContainerExclude:    []string{"istio-(proxy"},
+	}
+
+	valid, err := settings.Valid()
+	if valid {
+		t.Errorf("Expected settings to be invalid due to invalid container pattern")
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), `invalid container pattern "istio-(proxy"`) {
+		t.Errorf("Expected invalid container pattern error, got: %v", err)
+	}
+}
+
+func TestInvalidSettingsValueParsingDelimiter(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "test_env",
+		AnnotationBase:      "test_base",
+		AnnotationExtFormat: "test_ext_%d",
+		ValueParsing:        ValueParsing{Delimiters: []string{DelimiterComma, "|"}},
+	}
+
+	valid, err := settings.Valid()
+	if valid {
+		t.Errorf("Expected settings to be invalid due to unknown delimiter")
+	}
+	expectedError := `value_parsing delimiter "|" is not one of comma, colon, semicolon, newline`
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+}
+
+func TestInvalidSettingsLookups(t *testing.T) {
+	tests := []struct {
+		lookups       Lookups
+		expectedError string
+	}{
+		{Lookups{ConfigMaps: true, OnMissing: "ignore"}, "lookups on_missing must be one of skip, fallback, reject"},
+		{
+			Lookups{ConfigMaps: true, OnMissing: OnMissingFallback},
+			"lookups fallback_value cannot be empty when on_missing is fallback",
+		},
+	}
+
+	for _, test := range tests {
+		settings := Settings{
+			EnvKey:              "test_env",
+			AnnotationBase:      "test_base",
+			AnnotationExtFormat: "test_ext_%d",
+			Lookups:             test.lookups,
+		}
+
+		valid, err := settings.Valid()
+		if valid {
+			t.Errorf("Expected settings to be invalid")
+		}
+		if err == nil || err.Error() != test.expectedError {
+			t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+		}
+	}
+}
+
+func TestEnvKeyMatchSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		envKey        string
+		envKeyMatch   string
+		base          string
+		expectedError string
+	}{
+		{"regex with capture placeholders", "LOG_PATH_(?P<name>.+)", EnvKeyMatchRegex, "logs/{name}-path", ""},
+		{"prefix with suffix placeholder", "LOG_PATH_", EnvKeyMatchPrefix, "logs/{suffix}-path", ""},
+		{"unknown mode", "LOG_PATH", "fuzzy", "test_base", "env_key_match must be one of exact, prefix, glob, regex"},
+		{
+			"invalid regex", "LOG_PATH_(", EnvKeyMatchRegex, "test_base",
+			"env_key is not a valid regex: error parsing regexp: missing closing ): `^(?:LOG_PATH_()$`",
+		},
+		{
+			"unknown placeholder", "LOG_PATH_(?P<name>.+)", EnvKeyMatchRegex, "logs/{kind}-path",
+			"annotation_base: placeholder {kind} is not a capture group of env_key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:              test.envKey,
+				EnvKeyMatch:         test.envKeyMatch,
+				AnnotationBase:      test.base,
+				AnnotationExtFormat: "test_ext_%d",
+			}
+
+			valid, err := settings.Valid()
+			if test.expectedError == "" {
+				if !valid {
+					t.Errorf("Expected settings to be valid, got error: %v", err)
+				}
+				return
+			}
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
+
 func TestNewSettingsFromValidationReqWithValidSettings(t *testing.T) {
 	rawSettings := []byte(`{
       "env_key": "my_env",
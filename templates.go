@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+const (
+	// TemplateModeFormat builds the annotation keys with fmt verbs and {name} placeholders.
+	TemplateModeFormat = "format"
+	// TemplateModeGoTemplate renders the annotation keys and the additional annotation values
+	// as Go text/template templates.
+	TemplateModeGoTemplate = "go_template"
+)
+
+// requestInfo describes the object of an admission request.
+type requestInfo struct {
+	// Namespace is the namespace of the request.
+	Namespace string
+	// Name is the name of the object, or its generateName prefix when the name is not set yet.
+	Name string
+	// Kind is the kind of the object.
+	Kind string
+}
+
+// newRequestInfo extracts the description of the requested object from a validation request.
+func newRequestInfo(request kubewarden_protocol.ValidationRequest) requestInfo {
+	info := requestInfo{
+		Namespace: request.Request.Namespace,
+		Name:      request.Request.Name,
+		Kind:      request.Request.Kind.Kind,
+	}
+	if info.Name != "" {
+		return info
+	}
+
+	var object struct {
+		Metadata struct {
+			Name         string `json:"name"`
+			GenerateName string `json:"generateName"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(request.Request.Object, &object); err == nil {
+		info.Name = object.Metadata.Name
+		if info.Name == "" {
+			info.Name = object.Metadata.GenerateName
+		}
+	}
+	return info
+}
+
+// templateData is the context object the templates are executed against.
+type templateData struct {
+	// Namespace is the namespace of the request.
+	Namespace string
+	// Name is the name of the requested object.
+	Name string
+	// Kind is the kind of the requested object.
+	Kind string
+	// Container is the name of the container declaring the log path.
+	Container string
+	// Env is the name of the environment variable declaring the log path.
+	Env string
+	// Path is the log path.
+	Path string
+	// Captures holds the capture groups of the env_key match.
+	Captures map[string]string
+	// Index is the 0-based position of the log path among all the discovered ones.
+	Index int
+	// Seq is the position of the log path among the ones sharing the same base key:
+	// 0 for the base annotation, 1, 2, 3... for the extended ones.
+	Seq int
+}
+
+// annotationRenderer builds the annotation keys and values according to the template mode.
+type annotationRenderer struct {
+	settings   Settings
+	base       *template.Template
+	ext        *template.Template
+	additional map[string]*template.Template
+	// conditional holds the value templates of each group of conditional_annotations.
+	conditional []map[string]*template.Template
+}
+
+// parseTemplate parses a template of the given setting.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid template: %w", name, err)
+	}
+	for _, associated := range tmpl.Templates() {
+		if err = checkNoFunctions(associated.Root); err != nil {
+			return nil, fmt.Errorf("%s is not a valid template: %w", name, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// checkNoFunctions returns an error when a template node calls a function. text/template calls them
+// with reflect.Value.Call, which TinyGo does not implement, so the policy would trap when executing them.
+func checkNoFunctions(node parse.Node) error {
+	var children []parse.Node
+	switch node := node.(type) {
+	case *parse.IdentifierNode:
+		return fmt.Errorf("functions are not supported, found %s", node.Ident)
+	case *parse.ListNode:
+		if node != nil {
+			children = node.Nodes
+		}
+	case *parse.ActionNode:
+		children = []parse.Node{node.Pipe}
+	case *parse.PipeNode:
+		if node != nil {
+			for _, command := range node.Cmds {
+				children = append(children, command)
+			}
+		}
+	case *parse.CommandNode:
+		children = node.Args
+	case *parse.ChainNode:
+		children = []parse.Node{node.Node}
+	case *parse.IfNode:
+		children = []parse.Node{node.Pipe, node.List, node.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{node.Pipe, node.List, node.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{node.Pipe, node.List, node.ElseList}
+	case *parse.TemplateNode:
+		children = []parse.Node{node.Pipe}
+	}
+	for _, child := range children {
+		if err := checkNoFunctions(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newAnnotationRenderer creates the renderer of the given settings, parsing the templates
+// when the template mode is go_template.
+func newAnnotationRenderer(settings Settings) (*annotationRenderer, error) {
+	renderer := &annotationRenderer{settings: settings}
+	if settings.TemplateMode != TemplateModeGoTemplate {
+		return renderer, nil
+	}
+
+	var err error
+	if renderer.base, err = parseTemplate("annotation_base", settings.AnnotationBase); err != nil {
+		return nil, err
+	}
+	if renderer.ext, err = parseTemplate("annotation_ext_format", settings.AnnotationExtFormat); err != nil {
+		return nil, err
+	}
+	renderer.additional, err = parseValueTemplates("additional_annotations", settings.AdditionalAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	for i, group := range settings.ConditionalAnnotations {
+		name := fmt.Sprintf("conditional_annotations[%d] annotations", i)
+		templates, groupErr := parseValueTemplates(name, group.Annotations)
+		if groupErr != nil {
+			return nil, groupErr
+		}
+		renderer.conditional = append(renderer.conditional, templates)
+	}
+	return renderer, nil
+}
+
+// parseValueTemplates parses the string values of the custom annotations of the given setting.
+func parseValueTemplates(name string, values map[string]interface{}) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for key, value := range values {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		tmpl, err := parseTemplate(name+" "+key, text)
+		if err != nil {
+			return nil, err
+		}
+		templates[key] = tmpl
+	}
+	return templates, nil
+}
+
+// execute renders a template against the given data.
+func execute(tmpl *template.Template, data templateData) (string, error) {
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("cannot render %s: %w", tmpl.Name(), err)
+	}
+	return rendered.String(), nil
+}
+
+// pathData returns the template data of the log path found at the given index.
+func pathData(info requestInfo, path logPath, index int) templateData {
+	return templateData{
+		Namespace: info.Namespace,
+		Name:      info.Name,
+		Kind:      info.Kind,
+		Container: path.Container,
+		Env:       path.Env,
+		Path:      path.Path,
+		Captures:  path.Captures,
+		Index:     index,
+	}
+}
+
+// baseKey returns the base annotation key of a log path.
+func (r *annotationRenderer) baseKey(path logPath, data templateData) (string, error) {
+	if r.base == nil {
+		baseKey, _ := annotationKeys(path, r.settings)
+		return baseKey, nil
+	}
+	data.Seq = 0
+	return execute(r.base, data)
+}
+
+// extKey returns the extended annotation key of a log path with the given sequence number.
+// The boolean is false when no extended key is configured.
+func (r *annotationRenderer) extKey(path logPath, data templateData, sequence int) (string, bool, error) {
+	if r.ext == nil {
+		_, extFormat := annotationKeys(path, r.settings)
+		if extFormat == "" {
+			return "", false, nil
+		}
+		return fmt.Sprintf(extFormat, sequence), true, nil
+	}
+	data.Seq = sequence
+	key, err := execute(r.ext, data)
+	return key, err == nil, err
+}
+
+// additionalValue returns the value of an additional annotation. String values are rendered
+// against the request data in go_template mode.
+func (r *annotationRenderer) additionalValue(key string, value interface{}, info requestInfo) (string, error) {
+	return r.renderValue(r.additional[key], key, value, info)
+}
+
+// conditionalValue returns the value of an annotation of the group of conditional annotations
+// found at the given index. String values are rendered against the request data in go_template mode.
+func (r *annotationRenderer) conditionalValue(
+	group int,
+	key string,
+	value interface{},
+	info requestInfo,
+) (string, error) {
+	var tmpl *template.Template
+	if group < len(r.conditional) {
+		tmpl = r.conditional[group][key]
+	}
+	return r.renderValue(tmpl, key, value, info)
+}
+
+// renderValue renders the value of a custom annotation with its template, if any, and encodes it
+// with the value encoding of its key.
+func (r *annotationRenderer) renderValue(
+	tmpl *template.Template,
+	key string,
+	value interface{},
+	info requestInfo,
+) (string, error) {
+	if tmpl != nil {
+		rendered, err := execute(tmpl, templateData{Namespace: info.Namespace, Name: info.Name, Kind: info.Kind})
+		if err != nil {
+			return "", err
+		}
+		value = rendered
+	}
+	encoded, err := encodeValue(value, r.settings.ValueEncodings[key])
+	if err != nil {
+		return "", fmt.Errorf("cannot encode the value of annotation %s: %w", key, err)
+	}
+	return encoded, nil
+}
+
+// sampleTemplateData returns the data used to check the templates when the settings are validated.
+func sampleTemplateData(container string, captureGroups []string) templateData {
+	captures := make(map[string]string, len(captureGroups))
+	for _, group := range captureGroups {
+		captures[group] = group
+	}
+	return templateData{
+		Namespace: "default",
+		Name:      "sample",
+		Kind:      "Pod",
+		Container: container,
+		Env:       "LOG_PATH",
+		Path:      "/var/log/sample.log",
+		Captures:  captures,
+	}
+}
+
+// validateTemplates parses the templates of the settings and executes them against sample data,
+// so that the errors are reported when the policy is loaded.
+func validateTemplates(settings Settings, captureGroups []string) error {
+	renderer, err := newAnnotationRenderer(settings)
+	if err != nil {
+		return err
+	}
+
+	if settings.numberedOutput() {
+		if err = validateTemplateKeys(renderer, settings.ContainerStrategy, captureGroups); err != nil {
+			return err
+		}
+	}
+
+	info := requestInfo{Namespace: "default", Name: "sample", Kind: "Pod"}
+	for key, value := range settings.AdditionalAnnotations {
+		if _, err = renderer.additionalValue(key, value, info); err != nil {
+			return err
+		}
+	}
+	for i, group := range settings.ConditionalAnnotations {
+		for key, value := range group.Annotations {
+			if _, err = renderer.conditionalValue(i, key, value, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateTemplateKeys renders the annotation keys for sample containers and checks that they are
+// valid and distinct.
+func validateTemplateKeys(renderer *annotationRenderer, containerStrategy string, captureGroups []string) error {
+	baseKeys := make(map[string]bool)
+	for i, container := range []string{"first", "second"} {
+		data := sampleTemplateData(container, captureGroups)
+		data.Index = 3 * i //nolint:mnd // each sample container has three log paths
+		path := logPath{Container: container}
+		keys := make(map[string]bool)
+		baseKey, err := renderer.baseKey(path, data)
+		if err == nil {
+			err = validateAnnotationKey(baseKey)
+		}
+		if err != nil {
+			return err
+		}
+		baseKeys[baseKey] = true
+		keys[baseKey] = true
+		for sequence := 1; sequence <= 2; sequence++ {
+			data.Index++
+			extKey, _, extErr := renderer.extKey(path, data, sequence)
+			if extErr == nil {
+				extErr = validateAnnotationKey(extKey)
+			}
+			if extErr != nil {
+				return extErr
+			}
+			keys[extKey] = true
+		}
+		if len(keys) != 3 { //nolint:mnd // the base key and the two extended keys rendered above
+			return errors.New("annotation_ext_format must render a different key for each sequence number, " +
+				"for example using {{.Seq}}")
+		}
+	}
+	if containerStrategy == ContainerStrategyPerContainer && len(baseKeys) == 1 {
+		return errors.New("annotation_base must render a different key for each container when " +
+			"container_strategy is " + ContainerStrategyPerContainer + ", for example using {{.Container}}")
+	}
+	return nil
+}
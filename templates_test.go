@@ -0,0 +1,230 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTemplateModeAnnotations(t *testing.T) {
+	tests := []struct {
+		name                string
+		fixture             string
+		settings            Settings
+		templatePath        []string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:    "pod",
+			fixture: "test_data/pod-multiple-containers.json",
+			settings: Settings{
+				EnvKey:              "vestack_varlog",
+				TemplateMode:        TemplateModeGoTemplate,
+				AnnotationBase:      "logs.example.com/{{.Container}}",
+				AnnotationExtFormat: "logs.example.com/{{.Container}}-{{.Seq}}",
+				ContainerStrategy:   ContainerStrategyPerContainer,
+				AdditionalAnnotations: map[string]interface{}{
+					"logs.example.com/source": "{{.Kind}}/{{.Name}}",
+					"logs.example.com/count":  2,
+				},
+			},
+			expectedAnnotations: map[string]string{
+				"logs.example.com/app":      "/var/log/app.log",
+				"logs.example.com/worker":   "/var/log/worker.log",
+				"logs.example.com/worker-1": "/var/log/worker-err.log",
+				"logs.example.com/source":   "Pod/multi",
+				"logs.example.com/count":    "2",
+			},
+		},
+		{
+			name:    "deployment",
+			fixture: "test_data/deployment-env.json",
+			settings: Settings{
+				EnvKey:              "vestack_varlog",
+				TemplateMode:        TemplateModeGoTemplate,
+				AnnotationBase:      "logs.example.com/{{.Namespace}}.{{.Name}}",
+				AnnotationExtFormat: "logs.example.com/{{.Namespace}}.{{.Name}}.{{.Index}}",
+			},
+			templatePath: []string{"spec", "template"},
+			expectedAnnotations: map[string]string{
+				"logs.example.com/default.nginx":   "/var/log/app.log",
+				"logs.example.com/default.nginx.1": "/var/log/err.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid, err := test.settings.Valid(); !valid {
+				t.Fatalf("Expected settings to be valid, got error: %v", err)
+			}
+
+			response := validateFixture(t, test.fixture, test.settings)
+			if test.templatePath == nil {
+				assertMutation(t, response, test.expectedAnnotations)
+				return
+			}
+
+			annotations := mutatedMap(t, response, append(test.templatePath, "metadata", "annotations")...)
+			if len(annotations) != len(test.expectedAnnotations) {
+				t.Errorf("Expected annotations %v, got %v", test.expectedAnnotations, annotations)
+			}
+			for key, expectedValue := range test.expectedAnnotations {
+				if annotations[key] != expectedValue {
+					t.Errorf("Expected annotation %s to be %s, got %v", key, expectedValue, annotations[key])
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateModeCaptures(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "LOG_PATH_(?P<name>[A-Z]+)",
+		EnvKeyMatch:         EnvKeyMatchRegex,
+		TemplateMode:        TemplateModeGoTemplate,
+		AnnotationBase:      "logs.example.com/{{.Captures.name}}",
+		AnnotationExtFormat: "logs.example.com/{{.Captures.name}}-{{.Seq}}",
+	}
+	logPaths := []logPath{
+		{Container: "app", Path: "/var/log/access.log", Captures: map[string]string{"name": "ACCESS"}},
+		{Container: "app", Path: "/var/log/access-2.log", Captures: map[string]string{"name": "ACCESS"}},
+	}
+
+	annotations, _, err := getAnnotations(logPaths, settings, requestInfo{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if annotations["logs.example.com/ACCESS"] != "/var/log/access.log" ||
+		annotations["logs.example.com/ACCESS-1"] != "/var/log/access-2.log" ||
+		len(annotations) != 2 {
+		t.Errorf("Unexpected annotations: %v", annotations)
+	}
+}
+
+func TestTemplateModeSettings(t *testing.T) {
+	tests := []struct {
+		name          string
+		settings      Settings
+		expectedError string
+	}{
+		{
+			name: "no fmt verb needed",
+			settings: Settings{
+				AnnotationBase:      "logs/{{.Container}}",
+				AnnotationExtFormat: "logs/{{.Container}}-{{.Seq}}",
+			},
+		},
+		{
+			name: "unknown mode",
+			settings: Settings{
+				TemplateMode:        "jinja",
+				AnnotationBase:      "logs",
+				AnnotationExtFormat: "logs_%d",
+			},
+			expectedError: "template_mode must be either format or go_template",
+		},
+		{
+			name: "parse error",
+			settings: Settings{
+				AnnotationBase:      "logs/{{.Container",
+				AnnotationExtFormat: "logs/{{.Seq}}",
+			},
+			expectedError: "annotation_base is not a valid template: " +
+				"template: annotation_base:1: unclosed action",
+		},
+		{
+			name: "unknown field",
+			settings: Settings{
+				AnnotationBase:      "logs",
+				AnnotationExtFormat: "logs/{{.Sequence}}",
+			},
+			expectedError: "cannot render annotation_ext_format: template: annotation_ext_format:1:7: " +
+				"executing \"annotation_ext_format\" at <.Sequence>: can't evaluate field Sequence in type main.templateData",
+		},
+		{
+			name: "unknown capture group",
+			settings: Settings{
+				AnnotationBase:      "logs/{{.Captures.name}}",
+				AnnotationExtFormat: "logs/{{.Seq}}",
+			},
+			expectedError: "cannot render annotation_base: template: annotation_base:1:16: " +
+				"executing \"annotation_base\" at <.Captures.name>: map has no entry for key \"name\"",
+		},
+		{
+			name: "constant extended key",
+			settings: Settings{
+				AnnotationBase:      "logs",
+				AnnotationExtFormat: "logs_ext",
+			},
+			expectedError: "annotation_ext_format must render a different key for each sequence number, " +
+				"for example using {{.Seq}}",
+		},
+		{
+			name: "per container without container",
+			settings: Settings{
+				AnnotationBase:      "logs",
+				AnnotationExtFormat: "logs_{{.Seq}}",
+				ContainerStrategy:   ContainerStrategyPerContainer,
+			},
+			expectedError: "annotation_base must render a different key for each container when " +
+				"container_strategy is per_container, for example using {{.Container}}",
+		},
+		{
+			name: "additional annotation",
+			settings: Settings{
+				AnnotationBase:        "logs",
+				AnnotationExtFormat:   "logs_{{.Seq}}",
+				AdditionalAnnotations: map[string]interface{}{"source": "{{.Namespace"},
+			},
+			expectedError: "additional_annotations source is not a valid template: " +
+				"template: additional_annotations source:1: unclosed action",
+		},
+		{
+			name: "function",
+			settings: Settings{
+				AnnotationBase:      "logs",
+				AnnotationExtFormat: `logs_{{printf "%02d" .Seq}}`,
+			},
+			expectedError: "annotation_ext_format is not a valid template: functions are not supported, found printf",
+		},
+		{
+			name: "function in a pipeline",
+			settings: Settings{
+				AnnotationBase:        "logs",
+				AnnotationExtFormat:   "logs_{{.Seq}}",
+				AdditionalAnnotations: map[string]interface{}{"source": "{{if .Kind}}{{.Kind | len}}{{end}}"},
+			},
+			expectedError: "additional_annotations source is not a valid template: functions are not supported, found len",
+		},
+		{
+			name: "function in a defined template",
+			settings: Settings{
+				AnnotationBase:      `{{define "key"}}{{print .Container}}{{end}}logs/{{template "key" .}}`,
+				AnnotationExtFormat: "logs_{{.Seq}}",
+			},
+			expectedError: "annotation_base is not a valid template: functions are not supported, found print",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.settings.EnvKey = "LOG_PATH"
+			if test.settings.TemplateMode == "" {
+				test.settings.TemplateMode = TemplateModeGoTemplate
+			}
+
+			valid, err := test.settings.Valid()
+			if test.expectedError == "" {
+				if !valid {
+					t.Errorf("Expected settings to be valid, got error: %v", err)
+				}
+				return
+			}
+			if valid {
+				t.Errorf("Expected settings to be invalid")
+			}
+			if err == nil || err.Error() != test.expectedError {
+				t.Errorf("Expected error '%s', got: %v", test.expectedError, err)
+			}
+		})
+	}
+}
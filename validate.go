@@ -3,11 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"strconv"
+	"maps"
+	"sort"
 	"strings"
 
-	appsv1 "github.com/kubewarden/k8s-objects/api/apps/v1"
 	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
 	kubewarden "github.com/kubewarden/policy-sdk-go"
 	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
 )
@@ -22,10 +23,17 @@ const (
 	DEPLOYMENT_KIND = "deployment"
 	REPLICASET_KIND = "ReplicaSet"
 	POD_KIND        = "pod"
+	// NO_OWNER_KIND is the pod_owner_kinds value matching Pods without owner references.
+	NO_OWNER_KIND = "none"
 )
 
 // validate is the entry point of the policy.
 func validate(payload []byte) ([]byte, error) {
+	return validateWithLookup(payload, newHostResourceLookup())
+}
+
+// validateWithLookup validates a request, fetching the referenced resources through the given lookup.
+func validateWithLookup(payload []byte, lookup ResourceLookup) ([]byte, error) {
 	var validationRequest kubewarden_protocol.ValidationRequest
 	if err := json.Unmarshal(payload, &validationRequest); err != nil {
 		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
@@ -36,91 +44,316 @@ func validate(payload []byte) ([]byte, error) {
 		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
 	}
 
-	switch strings.ToLower(validationRequest.Request.Kind.Kind) {
-	case POD_KIND:
-		return handlePod(validationRequest, settings)
-	case DEPLOYMENT_KIND:
-		return handleDeployment(validationRequest, settings)
-	default:
-		return kubewarden.AcceptRequest()
+	kind := validationRequest.Request.Kind
+	if strings.ToLower(kind.Kind) == POD_KIND && kind.Group == "" {
+		return handlePod(validationRequest, settings, lookup)
+	}
+	if templatePaths, ok := podTemplatePaths(kind, settings); ok {
+		return handleWorkload(validationRequest, settings, templatePaths, lookup)
 	}
+	return kubewarden.AcceptRequest()
 }
 
-// checkEnvVars checks the environment variables of a container and returns the log paths.
-func checkEnvVars(container *corev1.Container, envKey string) []string {
+// envValue is the value of an environment variable whose name matches env_key.
+type envValue struct {
+	// Name is the name of the environment variable.
+	Name string
+	// Value is the resolved value of the environment variable.
+	Value string
+	// Captures holds the capture groups of the env_key match.
+	Captures map[string]string
+}
+
+// checkEnvVars checks the environment variables of a container and returns the values of
+// the ones matching env_key. Values defined through valueFrom and envFrom are resolved by the
+// given resolver, and the $(VAR_NAME) references are expanded against the variables declared
+// earlier in the container, like the kubelet does. Variables declared in env take precedence
+// over the envFrom ones with the same name.
+func checkEnvVars(container *corev1.Container, matcher *envKeyMatcher, resolver *valueResolver) ([]envValue, error) {
 	if container == nil {
-		return nil
+		return nil, nil
 	}
 
-	var logPaths []string
+	resolved, err := resolver.envFromValues(container)
+	if err != nil {
+		return nil, err
+	}
+	envFromValues := maps.Clone(resolved)
+	envFromNames := make([]string, 0, len(envFromValues))
+	for name := range envFromValues {
+		envFromNames = append(envFromNames, name)
+	}
+	sort.Strings(envFromNames)
+
+	var values []envValue
+	overridden := make(map[string]bool)
 	for _, env := range container.Env {
-		if env.Name != nil && *env.Name == envKey {
-			logPaths = append(logPaths, env.Value)
+		if env == nil || env.Name == nil {
+			continue
+		}
+		overridden[*env.Name] = true
+
+		value, found, resolveErr := resolver.resolve(env)
+		if resolveErr != nil {
+			return nil, resolveErr
 		}
+		if !found {
+			continue
+		}
+		if env.ValueFrom == nil {
+			value = expandEnvReferences(value, resolved)
+		}
+		resolved[*env.Name] = value
+		if captures, matched := matcher.match(*env.Name); matched {
+			values = append(values, envValue{Name: *env.Name, Value: value, Captures: captures})
+		}
+	}
+
+	for _, name := range envFromNames {
+		if overridden[name] {
+			continue
+		}
+		if captures, matched := matcher.match(name); matched {
+			values = append(values, envValue{Name: name, Value: envFromValues[name], Captures: captures})
+		}
+	}
+	return values, nil
+}
+
+// annotationKeys returns the base and the extended annotation key format used for a log path.
+// The {container} placeholder is replaced in per_container mode, the placeholders named after
+// the capture groups of env_key are replaced by the lower case captured text.
+func annotationKeys(path logPath, settings Settings) (string, string) {
+	replacements := make([]string, 0, 2*(len(path.Captures)+1))
+	if settings.ContainerStrategy == ContainerStrategyPerContainer {
+		replacements = append(replacements, ContainerPlaceholder, path.Container)
+	}
+	for group, captured := range path.Captures {
+		replacements = append(replacements, "{"+group+"}", strings.ToLower(captured))
+	}
+	if len(replacements) == 0 {
+		return settings.AnnotationBase, settings.AnnotationExtFormat
 	}
-	return logPaths
+
+	replacer := strings.NewReplacer(replacements...)
+	return replacer.Replace(settings.AnnotationBase), replacer.Replace(settings.AnnotationExtFormat)
 }
 
-// getAnnotations generates annotations based on log paths and settings.
-func getAnnotations(logPaths []string, settings Settings) map[string]string {
+// getAnnotations generates annotations and labels based on log paths and settings.
+// The first log path using a base annotation key is assigned to it, the following ones
+// are assigned to the extended annotation keys. Depending on output_mode, all the log paths
+// are also or only written to the list annotation. When there is no log path, the no_match
+// fallback annotations are used. Each output is written to the annotations, to the labels
+// or to both according to its target.
+func getAnnotations(
+	logPaths []logPath,
+	settings Settings,
+	info requestInfo,
+) (map[string]string, map[string]string, error) {
+	renderer, err := newAnnotationRenderer(settings)
+	if err != nil {
+		return nil, nil, err
+	}
 	annotations := make(map[string]string)
+	labels := make(map[string]string)
 
 	if len(logPaths) > 0 {
-		// Set base annotation
-		annotations[settings.AnnotationBase] = logPaths[0]
-
-		// Set extended annotations
-		if len(logPaths) > 1 && settings.AnnotationExtFormat != "" {
-			for i, path := range logPaths[1:] {
-				extKey := fmt.Sprintf(settings.AnnotationExtFormat, i+1)
-				annotations[extKey] = path
+		if settings.numberedOutput() {
+			numbered := make(map[string]string)
+			if err = addNumberedAnnotations(numbered, logPaths, renderer, info); err != nil {
+				return nil, nil, err
 			}
+			addToTargets(annotations, labels, numbered, settings.Targets.LogPaths)
+		}
+		if settings.listOutput() {
+			list := *settings.ListAnnotation
+			value, listErr := listAnnotationValue(logPaths, list)
+			if listErr != nil {
+				return nil, nil, listErr
+			}
+			addToTargets(annotations, labels, map[string]string{list.Key: value}, settings.Targets.ListAnnotation)
 		}
 	} else {
-		annotations[LogEnabledAnnotation] = LogEnabledValue
+		addToTargets(annotations, labels, settings.NoMatch.fallbackAnnotations(), settings.Targets.NoMatch)
 	}
 
 	// Add additional annotations
+	additional := make(map[string]string)
 	for key, value := range settings.AdditionalAnnotations {
 		if value != nil {
-			annotations[key] = convertToString(value)
+			if additional[key], err = renderer.additionalValue(key, value, info); err != nil {
+				return nil, nil, err
+			}
 		}
 	}
 
-	return annotations
+	// Add the conditional annotations whose conditions are met, later groups taking precedence
+	for i, group := range settings.ConditionalAnnotations {
+		if !group.When.matches(logPaths, info) {
+			continue
+		}
+		for key, value := range group.Annotations {
+			if value == nil {
+				continue
+			}
+			if additional[key], err = renderer.conditionalValue(i, key, value, info); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	addToTargets(annotations, labels, additional, settings.Targets.AdditionalAnnotations)
+
+	return annotations, labels, nil
 }
 
-// isDeploymentPod checks if a Pod was created by a Deployment.
-func isDeploymentPod(pod *corev1.Pod) bool {
-	if pod.Metadata == nil || len(pod.Metadata.OwnerReferences) == 0 {
-		return false
+// addNumberedAnnotations assigns each log path to its base or extended annotation key.
+func addNumberedAnnotations(
+	annotations map[string]string,
+	logPaths []logPath,
+	renderer *annotationRenderer,
+	info requestInfo,
+) error {
+	if renderer.settings.stableExtKeys() {
+		return addStableAnnotations(annotations, logPaths, renderer, info)
 	}
 
-	// Check if it was created by a ReplicaSet
-	for _, owner := range pod.Metadata.OwnerReferences {
-		if owner != nil && *owner.Kind == REPLICASET_KIND {
+	sequences := make(map[string]int)
+	for index, path := range logPaths {
+		data := pathData(info, path, index)
+		baseKey, err := renderer.baseKey(path, data)
+		if err != nil {
+			return err
+		}
+		sequence := sequences[baseKey]
+		sequences[baseKey] = sequence + 1
+
+		if sequence == 0 {
+			// Set base annotation
+			annotations[baseKey] = path.Path
+			continue
+		}
+
+		// Set extended annotation
+		extKey, ok, err := renderer.extKey(path, data, sequence)
+		if err != nil {
+			return err
+		}
+		if ok {
+			annotations[extKey] = path.Path
+		}
+	}
+	return nil
+}
+
+// isEligiblePod checks if a Pod is owned by one of the given kinds.
+func isEligiblePod(pod *corev1.Pod, ownerKinds []string) bool {
+	var owners []*metav1.OwnerReference
+	if pod.Metadata != nil {
+		owners = pod.Metadata.OwnerReferences
+	}
+
+	for _, kind := range ownerKinds {
+		if strings.EqualFold(kind, NO_OWNER_KIND) && len(owners) == 0 {
 			return true
 		}
+		for _, owner := range owners {
+			if owner != nil && owner.Kind != nil && strings.EqualFold(*owner.Kind, kind) {
+				return true
+			}
+		}
 	}
 	return false
 }
 
-// updateAnnotations updates the annotations in a metadata map.
-func updateAnnotations(metadata map[string]interface{}, annotations map[string]string) {
-	existingAnnotations, ok := metadata["annotations"].(map[string]interface{})
+// updateMetadata resolves the conflicts of the generated annotations and labels with the existing ones,
+// validates them and adds them to a metadata map. When prune_stale is enabled, the annotations and labels
+// generated for a previous version of the object, as listed by the marker annotation of the object or of
+// its old version, are removed if they are not generated anymore. The marker only lists the keys the
+// policy created, never the ones users set beforehand. It returns false when the metadata map
+// is left unchanged, because the object already carries exactly the annotations and labels the policy
+// would produce, so that no-op patches are avoided.
+func updateMetadata(
+	metadata, oldMetadata map[string]interface{},
+	annotations, labels map[string]string,
+	settings Settings,
+) (bool, error) {
+	generated := managedKeys{Annotations: sortedKeys(annotations), Labels: sortedKeys(labels)}
+
+	existingAnnotations, _ := metadata["annotations"].(map[string]interface{})
+	annotations, err := resolveConflicts(annotations, existingAnnotations, "annotation", settings)
+	if err != nil {
+		return false, err
+	}
+	existingLabels, _ := metadata["labels"].(map[string]interface{})
+	if labels, err = resolveConflicts(labels, existingLabels, "label", settings); err != nil {
+		return false, err
+	}
+	if labels, err = checkLabels(labels, settings); err != nil {
+		return false, err
+	}
+
+	pruned := false
+	if settings.PruneStale {
+		markerKey := settings.managedKeysAnnotation()
+		previous := previousManagedKeys(metadata, oldMetadata, markerKey)
+		owned := managedKeysValue(
+			ownedValues(annotations, existingAnnotations, previous.Annotations),
+			ownedValues(labels, existingLabels, previous.Labels),
+		)
+		pruned = pruneStaleKeys(metadata, previous, generated)
+		annotations[markerKey] = owned
+	}
+	if annotations, err = checkAnnotations(annotations, metadata, settings); err != nil {
+		return false, err
+	}
+
+	annotationsChanged := updateAnnotations(metadata, annotations)
+	labelsChanged := updateLabels(metadata, labels)
+	return pruned || annotationsChanged || labelsChanged, nil
+}
+
+// updateAnnotations updates the annotations in a metadata map. It returns true when an annotation changed.
+func updateAnnotations(metadata map[string]interface{}, annotations map[string]string) bool {
+	return updateMetadataField(metadata, "annotations", annotations)
+}
+
+// updateLabels updates the labels in a metadata map. It returns true when a label changed.
+func updateLabels(metadata map[string]interface{}, labels map[string]string) bool {
+	return updateMetadataField(metadata, "labels", labels)
+}
+
+// updateMetadataField merges the given values into a string map field of a metadata map.
+// It returns true when a value was added or changed.
+func updateMetadataField(metadata map[string]interface{}, field string, values map[string]string) bool {
+	if len(values) == 0 {
+		return false
+	}
+
+	existing, ok := metadata[field].(map[string]interface{})
 	if !ok {
-		existingAnnotations = make(map[string]interface{})
+		existing = make(map[string]interface{})
 	}
 
-	// Merge annotations
-	for k, v := range annotations {
-		existingAnnotations[k] = v
+	// Merge values
+	changed := false
+	for k, v := range values {
+		if current, found := existing[k].(string); !found || current != v {
+			existing[k] = v
+			changed = true
+		}
 	}
-	metadata["annotations"] = existingAnnotations
+	if changed {
+		metadata[field] = existing
+	}
+	return changed
 }
 
 // handlePod handles the validation and mutation of Pod resources.
-func handlePod(request kubewarden_protocol.ValidationRequest, settings Settings) ([]byte, error) {
+func handlePod(
+	request kubewarden_protocol.ValidationRequest,
+	settings Settings,
+	lookup ResourceLookup,
+) ([]byte, error) {
 	// Unmarshal the original object
 	var rawObj map[string]interface{}
 	if err := json.Unmarshal(request.Request.Object, &rawObj); err != nil {
@@ -133,91 +366,94 @@ func handlePod(request kubewarden_protocol.ValidationRequest, settings Settings)
 		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
 	}
 
-	// Only handle Pods created by a Deployment
-	if !isDeploymentPod(&pod) {
+	// Only handle Pods owned by one of the configured kinds
+	if !settings.allPodsEligible() && !isEligiblePod(&pod, settings.ownerKinds()) {
 		return kubewarden.AcceptRequest()
 	}
 
-	// Check the environment variables of the first container
-	var logPaths []string
-	if len(pod.Spec.Containers) > 0 {
-		logPaths = checkEnvVars(pod.Spec.Containers[0], settings.EnvKey)
+	// Check the environment variables of all the containers
+	resolver := newValueResolver(lookup, request.Request.Namespace, settings.Lookups)
+	logPaths, err := collectLogPaths(pod.Spec, settings, resolver)
+	if err != nil {
+		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
 	}
 
-	// Generate annotations
-	annotations := getAnnotations(logPaths, settings)
-
-	// Update the annotations of the original object
-	metadata, ok := rawObj["metadata"].(map[string]interface{})
-	if !ok {
-		metadata = make(map[string]interface{})
-		rawObj["metadata"] = metadata
+	// Deduplicate, order and limit the log paths
+	if logPaths, err = selectLogPaths(logPaths, settings.PathSelection); err != nil {
+		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
 	}
-	updateAnnotations(metadata, annotations)
-
-	return kubewarden.MutateRequest(rawObj)
-}
 
-// handleDeployment handles the validation and mutation of Deployment resources.
-func handleDeployment(request kubewarden_protocol.ValidationRequest, settings Settings) ([]byte, error) {
-	// Unmarshal the original object
-	var rawObj map[string]interface{}
-	if err := json.Unmarshal(request.Request.Object, &rawObj); err != nil {
-		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
+	// Mount the log directories, the pod spec can only be changed when the Pod is created
+	mounted := false
+	if request.Request.Operation != "UPDATE" {
+		spec, _ := rawObj["spec"].(map[string]interface{})
+		mounted = mountLogDirectories(spec, logPaths, settings.AutoMount)
 	}
 
-	// Unmarshal to a Deployment object for checking
-	var deployment appsv1.Deployment
-	if err := json.Unmarshal(request.Request.Object, &deployment); err != nil {
+	// Update the annotations and labels of the original object
+	annotated, err := annotateObject(rawObj, unmarshalOldObject(request), logPaths, settings, newRequestInfo(request))
+	if err != nil {
 		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
 	}
-
-	if deployment.Spec == nil || deployment.Spec.Template.Spec == nil {
+	if !mounted && !annotated {
 		return kubewarden.AcceptRequest()
 	}
 
-	// Check the environment variables of the first container
-	var logPaths []string
-	if len(deployment.Spec.Template.Spec.Containers) > 0 {
-		logPaths = checkEnvVars(deployment.Spec.Template.Spec.Containers[0], settings.EnvKey)
-	}
-
-	// Generate annotations
-	annotations := getAnnotations(logPaths, settings)
+	return kubewarden.MutateRequest(rawObj)
+}
 
-	// Update the annotations of the original object
-	spec, ok := rawObj["spec"].(map[string]interface{})
-	if !ok {
-		return kubewarden.RejectRequest(kubewarden.Message("Invalid deployment spec"), kubewarden.Code(RejectCode))
+// annotateObject adds the annotations and labels generated from the log paths, once selected, to the metadata
+// of a raw object, such as a Pod, a pod template or a workload. The old object is the one of an UPDATE request,
+// if any. It returns false when the object is left unchanged, and an error when the request must be rejected.
+func annotateObject(
+	obj, oldObj map[string]interface{},
+	logPaths []logPath,
+	settings Settings,
+	info requestInfo,
+) (bool, error) {
+	oldMetadata, _ := oldObj["metadata"].(map[string]interface{})
+	if mutate, noMatchErr := checkNoMatch(logPaths, settings); !mutate {
+		// Nothing is generated anymore, the previously managed keys are all stale
+		metadata, ok := obj["metadata"].(map[string]interface{})
+		if noMatchErr != nil || !ok || !settings.PruneStale {
+			return false, noMatchErr
+		}
+		return pruneManagedKeys(metadata, oldMetadata, settings.managedKeysAnnotation()), nil
 	}
 
-	template, ok := spec["template"].(map[string]interface{})
-	if !ok {
-		return kubewarden.RejectRequest(kubewarden.Message("Invalid deployment template"), kubewarden.Code(RejectCode))
+	// Generate annotations and labels
+	annotations, labels, err := getAnnotations(logPaths, settings, info)
+	if err != nil {
+		return false, err
 	}
 
-	metadata, ok := template["metadata"].(map[string]interface{})
+	// Update the annotations and labels of the object
+	metadata, ok := obj["metadata"].(map[string]interface{})
 	if !ok {
 		metadata = make(map[string]interface{})
-		template["metadata"] = metadata
+		obj["metadata"] = metadata
 	}
-	updateAnnotations(metadata, annotations)
+	return updateMetadata(metadata, oldMetadata, annotations, labels, settings)
+}
 
-	return kubewarden.MutateRequest(rawObj)
+// unmarshalOldObject returns the raw old object of an UPDATE request, or nil when there is none.
+func unmarshalOldObject(request kubewarden_protocol.ValidationRequest) map[string]interface{} {
+	var oldObj map[string]interface{}
+	if len(request.Request.OldObject) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(request.Request.OldObject, &oldObj); err != nil {
+		logger.WarnWith("cannot decode the old object").Err("error", err).Write()
+		return nil
+	}
+	return oldObj
 }
 
-// convertToString converts any type to a string.
+// convertToString converts any type to a string, using the raw encoding.
 func convertToString(value interface{}) string {
-	switch v := value.(type) {
-	case string:
-		return v
-	case bool:
-		return strconv.FormatBool(v)
-	case int, int32, int64:
-		return fmt.Sprintf("%d", v)
-	case float32, float64:
-		return fmt.Sprintf("%g", v)
-	default:
-		return fmt.Sprintf("%v", v)
+	encoded, err := encodeValue(value, EncodingRaw)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
 	}
+	return encoded
 }
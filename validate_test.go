@@ -471,6 +471,18 @@ func assertNoMutation(t *testing.T, response *kubewarden_protocol.ValidationResp
 	}
 }
 
+func assertRejection(t *testing.T, response *kubewarden_protocol.ValidationResponse, expectedMessage string) {
+	t.Helper()
+
+	if response.Accepted {
+		t.Errorf("Expected request to be rejected with message '%s'", expectedMessage)
+		return
+	}
+	if response.Message == nil || *response.Message != expectedMessage {
+		t.Errorf("Expected rejection message '%s', got %v", expectedMessage, response.Message)
+	}
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
@@ -482,3 +494,240 @@ func mustMarshalJSON(obj interface{}) []byte {
 	}
 	return data
 }
+
+func TestPodOwnerKinds(t *testing.T) {
+	container := &corev1.Container{
+		Name: stringPtr("my-container"),
+		Env: []*corev1.EnvVar{
+			{
+				Name:  stringPtr("LOG_PATH"),
+				Value: "/var/log/app.log",
+			},
+		},
+	}
+	ownedBy := func(kind string) *metav1.ObjectMeta {
+		return &metav1.ObjectMeta{
+			OwnerReferences: []*metav1.OwnerReference{
+				{
+					APIVersion: stringPtr("apps/v1"),
+					Kind:       stringPtr(kind),
+					Name:       stringPtr("test-owner"),
+					UID:        stringPtr("test-uid"),
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		ownerKinds    []string
+		metadata      *metav1.ObjectMeta
+		shouldMutate  bool
+		expectedValue string
+	}{
+		{"default kinds accept ReplicaSet pods", nil, ownedBy("ReplicaSet"), true, "/var/log/app.log"},
+		{"default kinds ignore StatefulSet pods", nil, ownedBy("StatefulSet"), false, ""},
+		{"default kinds ignore bare pods", nil, &metav1.ObjectMeta{}, false, ""},
+		{"configured kinds accept StatefulSet pods", []string{"StatefulSet", "Job"}, ownedBy("StatefulSet"), true,
+			"/var/log/app.log"},
+		{"configured kinds ignore ReplicaSet pods", []string{"StatefulSet"}, ownedBy("ReplicaSet"), false, ""},
+		{"none accepts bare pods", []string{"none"}, &metav1.ObjectMeta{}, true, "/var/log/app.log"},
+		{"none accepts pods without metadata", []string{"none"}, nil, true, "/var/log/app.log"},
+		{"none ignores owned pods", []string{"none"}, ownedBy("ReplicaSet"), false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:         "LOG_PATH",
+				AnnotationBase: "co_elastic_logs_path",
+				PodOwnerKinds:  test.ownerKinds,
+			}
+			pod := corev1.Pod{
+				Metadata: test.metadata,
+				Spec: &corev1.PodSpec{
+					Containers: []*corev1.Container{container},
+				},
+			}
+
+			response, err := validateTest(t, kubewarden_protocol.ValidationRequest{
+				Request: kubewarden_protocol.KubernetesAdmissionRequest{
+					Kind:   kubewarden_protocol.GroupVersionKind{Kind: "Pod"},
+					Object: mustMarshalJSON(pod),
+				},
+				Settings: mustMarshalJSON(settings),
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if test.shouldMutate {
+				assertMutation(t, response, map[string]string{"co_elastic_logs_path": test.expectedValue})
+			} else {
+				assertNoMutation(t, response)
+			}
+		})
+	}
+}
+
+func TestMultipleContainersStrategies(t *testing.T) {
+	tests := []struct {
+		name                string
+		settings            Settings
+		expectedAnnotations map[string]string
+	}{
+		{
+			name: "flat",
+			settings: Settings{
+				EnvKey:              "vestack_varlog",
+				AnnotationBase:      "co_elastic_logs_path",
+				AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+			},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/worker.log",
+				"co_elastic_logs_path_ext_2": "/var/log/worker-err.log",
+			},
+		},
+		{
+			name: "per container",
+			settings: Settings{
+				EnvKey:              "vestack_varlog",
+				AnnotationBase:      "co_elastic_logs_path_{container}",
+				AnnotationExtFormat: "co_elastic_logs_path_{container}_ext_%d",
+				ContainerStrategy:   ContainerStrategyPerContainer,
+			},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path_app":          "/var/log/app.log",
+				"co_elastic_logs_path_worker":       "/var/log/worker.log",
+				"co_elastic_logs_path_worker_ext_1": "/var/log/worker-err.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := validateFixture(t, "test_data/pod-multiple-containers.json", test.settings)
+			assertMutation(t, response, test.expectedAnnotations)
+		})
+	}
+}
+
+func TestContainerSelection(t *testing.T) {
+	tests := []struct {
+		name                string
+		includeInit         bool
+		include             []string
+		exclude             []string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name: "regular containers only",
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/envoy.log",
+			},
+		},
+		{
+			name:        "init containers included",
+			includeInit: true,
+			exclude:     []string{"istio-proxy"},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/migrate.log",
+			},
+		},
+		{
+			name:        "include by regex",
+			includeInit: true,
+			include:     []string{"mig.*", "app"},
+			expectedAnnotations: map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/migrate.log",
+			},
+		},
+		{
+			name:    "partial names do not match",
+			include: []string{"istio"},
+			expectedAnnotations: map[string]string{
+				LogEnabledAnnotation: LogEnabledValue,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := Settings{
+				EnvKey:                "vestack_varlog",
+				AnnotationBase:        "co_elastic_logs_path",
+				AnnotationExtFormat:   "co_elastic_logs_path_ext_%d",
+				IncludeInitContainers: test.includeInit,
+				ContainerInclude:      test.include,
+				ContainerExclude:      test.exclude,
+			}
+
+			response := validateFixture(t, "test_data/pod-init-containers.json", settings)
+			assertMutation(t, response, test.expectedAnnotations)
+		})
+	}
+}
+
+func TestUnchangedObjectsAreNotPatched(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		PruneStale:          true,
+	}
+
+	tests := []struct {
+		fixture      string
+		metadataPath []string
+		annotations  map[string]interface{}
+	}{
+		{
+			"test_data/pod-multiple-containers.json",
+			[]string{"metadata"},
+			map[string]interface{}{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/worker.log",
+				"co_elastic_logs_path_ext_2": "/var/log/worker-err.log",
+			},
+		},
+		{
+			"test_data/deployment-env.json",
+			[]string{"spec", "template", "metadata"},
+			map[string]interface{}{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/err.log",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			annotations := make(map[string]interface{})
+			managed := make(map[string]string)
+			for key, value := range test.annotations {
+				annotations[key] = value
+				managed[key] = value.(string)
+			}
+			annotations["example.com/owner"] = "team-a"
+			annotations[DefaultManagedKeysAnnotation] = managedKeysValue(managed, nil)
+
+			response := validateFixtureWithAnnotations(t, test.fixture, test.metadataPath, annotations, settings)
+			if !response.Accepted {
+				t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+			}
+			assertNoMutation(t, response)
+
+			// A changed value is patched
+			annotations["co_elastic_logs_path"] = "/var/log/old.log"
+			response = validateFixtureWithAnnotations(t, test.fixture, test.metadataPath, annotations, settings)
+			mutated := mutatedMap(t, response, append(test.metadataPath, "annotations")...)
+			if mutated["co_elastic_logs_path"] != "/var/log/app.log" {
+				t.Errorf("Expected annotation co_elastic_logs_path to be updated, got %v", mutated)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	kubewarden "github.com/kubewarden/policy-sdk-go"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+// podTemplatePath returns the location of the pod template inside of a workload
+// of the given (lower case) kind. The kinds are the ones supported by
+// kubewarden.ExtractPodSpecFromObject, Pods excluded.
+func podTemplatePath(kind string) ([]string, bool) {
+	switch kind {
+	case DEPLOYMENT_KIND, "replicaset", "statefulset", "daemonset", "replicationcontroller", "job":
+		return []string{"spec", "template"}, true
+	case "cronjob":
+		return []string{"spec", "jobTemplate", "spec", "template"}, true
+	default:
+		return nil, false
+	}
+}
+
+// podTemplatePaths returns the locations of the pod templates inside of the requested object.
+// Custom resources configured in the settings take precedence over the built-in workload kinds.
+func podTemplatePaths(kind kubewarden_protocol.GroupVersionKind, settings Settings) ([][]string, bool) {
+	for _, resource := range settings.CustomResources {
+		if !resource.matches(kind) {
+			continue
+		}
+		var paths [][]string
+		for _, pointer := range resource.TemplatePaths {
+			path, err := parseJSONPointer(pointer)
+			if err != nil {
+				logger.Warn("ignoring invalid template path " + pointer)
+				continue
+			}
+			paths = append(paths, path)
+		}
+		return paths, true
+	}
+
+	path, ok := podTemplatePath(strings.ToLower(kind.Kind))
+	if !ok {
+		return nil, false
+	}
+	return [][]string{path}, true
+}
+
+// parseJSONPointer splits a JSON pointer (RFC 6901) into its unescaped reference tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, errors.New("JSON pointer cannot be empty")
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("JSON pointer must start with '/'")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// lookupMap walks the given path inside of a raw object and returns the map found there.
+// Numeric path segments are used as indexes when walking through lists.
+func lookupMap(obj map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	var current interface{} = obj
+	for _, segment := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			current = node[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+
+	found, ok := current.(map[string]interface{})
+	return found, ok
+}
+
+// decodePodTemplate converts a raw pod template into a PodTemplateSpec.
+func decodePodTemplate(template map[string]interface{}) (corev1.PodTemplateSpec, error) {
+	var podTemplate corev1.PodTemplateSpec
+	raw, err := json.Marshal(template)
+	if err != nil {
+		return podTemplate, err
+	}
+	err = json.Unmarshal(raw, &podTemplate)
+	return podTemplate, err
+}
+
+// templateLogPaths returns the log paths declared by the containers of a raw pod template.
+// It returns false when the template has no pod spec.
+func templateLogPaths(
+	template map[string]interface{},
+	settings Settings,
+	resolver *valueResolver,
+) ([]logPath, bool, error) {
+	// Decode the pod template for checking
+	podTemplate, err := decodePodTemplate(template)
+	if err != nil {
+		return nil, false, err
+	}
+	if podTemplate.Spec == nil {
+		return nil, false, nil
+	}
+
+	// Check the environment variables of all the containers
+	logPaths, err := collectLogPaths(podTemplate.Spec, settings, resolver)
+	return logPaths, true, err
+}
+
+// immutablePodTemplate checks if the pod template of a workload kind cannot be changed once the
+// workload is created, like the one of the Jobs.
+func immutablePodTemplate(kind kubewarden_protocol.GroupVersionKind) bool {
+	return kind.Group == "batch" && strings.EqualFold(kind.Kind, "job")
+}
+
+// mutatePodTemplate adds the annotations computed from the containers of a raw pod template
+// to the template metadata and, when auto_mount is enabled, the volume mounts of the log directories
+// to its pod spec. The old template is the one of the old object of an UPDATE request, if any.
+// It returns false when the template has no pod spec, when no log path is found and no_match is
+// ignore, or when the template already carries the annotations, labels and volume mounts that
+// would be added.
+func mutatePodTemplate(
+	template, oldTemplate map[string]interface{},
+	settings Settings,
+	resolver *valueResolver,
+	info requestInfo,
+) (bool, error) {
+	logPaths, found, err := templateLogPaths(template, settings, resolver)
+	if err != nil || !found {
+		return false, err
+	}
+
+	// Deduplicate, order and limit the log paths, so that only the kept ones are mounted
+	if logPaths, err = selectLogPaths(logPaths, settings.PathSelection); err != nil {
+		return false, err
+	}
+
+	spec, _ := template["spec"].(map[string]interface{})
+	mounted := mountLogDirectories(spec, logPaths, settings.AutoMount)
+	annotated, err := annotateObject(template, oldTemplate, logPaths, settings, info)
+	return mounted || annotated, err
+}
+
+// mutateWorkloadMetadata adds the annotations computed from the containers of all the pod templates
+// of a raw workload to the top-level metadata of the workload, leaving the pod templates untouched.
+func mutateWorkloadMetadata(
+	obj, oldObj map[string]interface{},
+	templatePaths [][]string,
+	settings Settings,
+	resolver *valueResolver,
+	info requestInfo,
+) (bool, error) {
+	var logPaths []logPath
+	foundTemplate := false
+	for _, templatePath := range templatePaths {
+		template, ok := lookupMap(obj, templatePath)
+		if !ok {
+			continue
+		}
+
+		paths, found, err := templateLogPaths(template, settings, resolver)
+		if err != nil {
+			return false, err
+		}
+		foundTemplate = foundTemplate || found
+		logPaths = append(logPaths, paths...)
+	}
+
+	if !foundTemplate {
+		return false, nil
+	}
+
+	// Deduplicate, order and limit the log paths of all the pod templates together
+	logPaths, err := selectLogPaths(logPaths, settings.PathSelection)
+	if err != nil {
+		return false, err
+	}
+	return annotateObject(obj, oldObj, logPaths, settings, info)
+}
+
+// annotatedController checks if the policy annotates the pod template of a controller kind:
+// Deployments, CronJobs and the configured custom resources.
+func annotatedController(kind kubewarden_protocol.GroupVersionKind, settings Settings) bool {
+	for _, resource := range settings.CustomResources {
+		if resource.matches(kind) {
+			return true
+		}
+	}
+	switch strings.ToLower(kind.Kind) {
+	case DEPLOYMENT_KIND:
+		return kind.Group == "apps"
+	case "cronjob":
+		return kind.Group == "batch"
+	default:
+		return false
+	}
+}
+
+// controlledByAnnotatedWorkload checks if a raw object has a controller owner reference to a workload
+// whose pod template is annotated, like the ReplicaSets created by Deployments and the Jobs created by
+// CronJobs. Their pod templates are copied from the template of the controller: the controller compares
+// both templates, so any difference would make it consider the object foreign and create new ones.
+// The objects created by other controllers, such as the StatefulSets of operators, are annotated.
+func controlledByAnnotatedWorkload(obj map[string]interface{}, settings Settings) bool {
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	owners, _ := metadata["ownerReferences"].([]interface{})
+	for _, item := range owners {
+		owner, ok := item.(map[string]interface{})
+		if !ok || owner["controller"] != true {
+			continue
+		}
+		apiVersion, _ := owner["apiVersion"].(string)
+		kind, _ := owner["kind"].(string)
+		group, version, found := strings.Cut(apiVersion, "/")
+		if !found {
+			group, version = "", apiVersion
+		}
+		ownerKind := kubewarden_protocol.GroupVersionKind{Group: group, Version: version, Kind: kind}
+		if annotatedController(ownerKind, settings) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWorkload handles the validation and mutation of resources embedding pod templates,
+// such as Deployments, StatefulSets, DaemonSets, Jobs, CronJobs and the configured custom resources.
+// Depending on the placement, the pod templates or the workload metadata are annotated, or the
+// workload is left untouched so that its Pods are annotated when they are created.
+func handleWorkload(
+	request kubewarden_protocol.ValidationRequest,
+	settings Settings,
+	templatePaths [][]string,
+	lookup ResourceLookup,
+) ([]byte, error) {
+	if settings.Placement == PlacementPod {
+		return kubewarden.AcceptRequest()
+	}
+
+	// Immutable pod templates can only be annotated when the workload is created, any change
+	// would make the API server reject unrelated updates, such as the ones of the labels
+	if settings.Placement != PlacementWorkload && request.Request.Operation == "UPDATE" &&
+		immutablePodTemplate(request.Request.Kind) {
+		return kubewarden.AcceptRequest()
+	}
+
+	// Unmarshal the original object
+	var rawObj map[string]interface{}
+	if err := json.Unmarshal(request.Request.Object, &rawObj); err != nil {
+		return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
+	}
+
+	// Leave the objects created from an annotated template to their controller
+	if controlledByAnnotatedWorkload(rawObj, settings) {
+		return kubewarden.AcceptRequest()
+	}
+
+	resolver := newValueResolver(lookup, request.Request.Namespace, settings.Lookups)
+	info := newRequestInfo(request)
+	oldObj := unmarshalOldObject(request)
+	mutated := false
+	if settings.Placement == PlacementWorkload {
+		var err error
+		mutated, err = mutateWorkloadMetadata(rawObj, oldObj, templatePaths, settings, resolver, info)
+		if err != nil {
+			return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
+		}
+	} else {
+		for _, templatePath := range templatePaths {
+			template, ok := lookupMap(rawObj, templatePath)
+			if !ok {
+				continue
+			}
+
+			oldTemplate, _ := lookupMap(oldObj, templatePath)
+			templateMutated, err := mutatePodTemplate(template, oldTemplate, settings, resolver, info)
+			if err != nil {
+				return kubewarden.RejectRequest(kubewarden.Message(err.Error()), kubewarden.Code(RejectCode))
+			}
+			mutated = mutated || templateMutated
+		}
+	}
+
+	if !mutated {
+		return kubewarden.AcceptRequest()
+	}
+	return kubewarden.MutateRequest(rawObj)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "github.com/kubewarden/k8s-objects/api/core/v1"
+	metav1 "github.com/kubewarden/k8s-objects/apimachinery/pkg/apis/meta/v1"
+	kubewarden_protocol "github.com/kubewarden/policy-sdk-go/protocol"
+)
+
+// loadFixture reads the admission request stored in the given fixture. When modify is not nil,
+// it is called with the raw object of the request, which is then replaced by the modified one.
+func loadFixture(
+	t *testing.T,
+	fixture string,
+	modify func(object map[string]interface{}),
+) kubewarden_protocol.KubernetesAdmissionRequest {
+	t.Helper()
+
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", fixture, err)
+	}
+
+	var admissionRequest kubewarden_protocol.KubernetesAdmissionRequest
+	if err = json.Unmarshal(data, &admissionRequest); err != nil {
+		t.Fatalf("Failed to unmarshal fixture %s: %v", fixture, err)
+	}
+	if modify == nil {
+		return admissionRequest
+	}
+
+	var object map[string]interface{}
+	if err = json.Unmarshal(admissionRequest.Object, &object); err != nil {
+		t.Fatalf("Failed to unmarshal object of fixture %s: %v", fixture, err)
+	}
+	modify(object)
+	admissionRequest.Object = mustMarshalJSON(object)
+	return admissionRequest
+}
+
+// withAnnotationsAt returns a modify function for loadFixture replacing the annotations
+// of the metadata found at the given path.
+func withAnnotationsAt(
+	t *testing.T,
+	metadataPath []string,
+	annotations map[string]interface{},
+) func(object map[string]interface{}) {
+	return func(object map[string]interface{}) {
+		t.Helper()
+
+		metadata, ok := lookupMap(object, metadataPath)
+		if !ok {
+			t.Fatalf("Expected %v to be present in the fixture object", metadataPath)
+		}
+		metadata["annotations"] = annotations
+	}
+}
+
+// podRequest returns a CREATE request for a Pod of the default namespace, without owner, whose single
+// container is named app and declares the given environment variables. When modify is not nil, it is
+// called with the Pod before the request is built. Pods without owner are eligible, unless the settings
+// configure pod_owner_kinds.
+func podRequest(
+	settings Settings,
+	modify func(pod *corev1.Pod),
+	env ...*corev1.EnvVar,
+) kubewarden_protocol.ValidationRequest {
+	if len(settings.PodOwnerKinds) == 0 {
+		settings.PodOwnerKinds = []string{NO_OWNER_KIND}
+	}
+	pod := corev1.Pod{
+		Metadata: &metav1.ObjectMeta{Name: "app"},
+		Spec: &corev1.PodSpec{
+			Containers: []*corev1.Container{{Name: stringPtr("app"), Env: env}},
+		},
+	}
+	if modify != nil {
+		modify(&pod)
+	}
+
+	return kubewarden_protocol.ValidationRequest{
+		Request: kubewarden_protocol.KubernetesAdmissionRequest{
+			Kind:      kubewarden_protocol.GroupVersionKind{Kind: "Pod"},
+			Operation: "CREATE",
+			Namespace: "default",
+			Object:    mustMarshalJSON(pod),
+		},
+		Settings: mustMarshalJSON(settings),
+	}
+}
+
+// validateFixture executes the policy against the admission request stored in the given fixture.
+func validateFixture(t *testing.T, fixture string, settings Settings) *kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	return validateModifiedFixture(t, fixture, nil, settings)
+}
+
+// validateModifiedFixture executes the policy against the admission request stored in the given fixture,
+// once its object is changed by the modify function.
+func validateModifiedFixture(
+	t *testing.T,
+	fixture string,
+	modify func(object map[string]interface{}),
+	settings Settings,
+) *kubewarden_protocol.ValidationResponse {
+	t.Helper()
+
+	response, err := validateTest(t, kubewarden_protocol.ValidationRequest{
+		Request:  loadFixture(t, fixture, modify),
+		Settings: mustMarshalJSON(settings),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return response
+}
+
+// mutatedMap returns the map found at the given path inside of the mutated object.
+func mutatedMap(
+	t *testing.T,
+	response *kubewarden_protocol.ValidationResponse,
+	path ...string,
+) map[string]interface{} {
+	t.Helper()
+
+	if !response.Accepted {
+		t.Fatalf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+
+	var mutated map[string]interface{}
+	if err := json.Unmarshal(mustMarshalJSON(response.MutatedObject), &mutated); err != nil {
+		t.Fatalf("Failed to unmarshal mutated object: %v", err)
+	}
+
+	found, ok := lookupMap(mutated, path)
+	if !ok {
+		t.Fatalf("Expected %v to be present in the mutated object", path)
+	}
+	return found
+}
+
+func TestPodTemplateWorkloadMutation(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+	}
+
+	tests := []struct {
+		fixture      string
+		templatePath []string
+	}{
+		{"test_data/deployment-env.json", []string{"spec", "template"}},
+		{"test_data/replicaset-env.json", []string{"spec", "template"}},
+		{"test_data/statefulset-env.json", []string{"spec", "template"}},
+		{"test_data/daemonset-env.json", []string{"spec", "template"}},
+		{"test_data/replicationcontroller-env.json", []string{"spec", "template"}},
+		{"test_data/job-env.json", []string{"spec", "template"}},
+		{"test_data/cronjob-env.json", []string{"spec", "jobTemplate", "spec", "template"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			response := validateFixture(t, test.fixture, settings)
+			annotations := mutatedMap(t, response, append(test.templatePath, "metadata", "annotations")...)
+
+			expectedAnnotations := map[string]string{
+				"co_elastic_logs_path":       "/var/log/app.log",
+				"co_elastic_logs_path_ext_1": "/var/log/err.log",
+			}
+			for key, expectedValue := range expectedAnnotations {
+				if annotations[key] != expectedValue {
+					t.Errorf("Expected annotation %s to be %s, got %v", key, expectedValue, annotations[key])
+				}
+			}
+			if len(annotations) != len(expectedAnnotations) {
+				t.Errorf("Expected %d annotations, got %v", len(expectedAnnotations), annotations)
+			}
+		})
+	}
+}
+
+func TestPodTemplatePath(t *testing.T) {
+	if _, ok := podTemplatePath("service"); ok {
+		t.Error("Expected services not to have a pod template path")
+	}
+	if _, ok := podTemplatePath(POD_KIND); ok {
+		t.Error("Expected pods to be handled without a pod template path")
+	}
+
+	path, ok := podTemplatePath("cronjob")
+	if !ok || len(path) != 4 || path[1] != "jobTemplate" {
+		t.Errorf("Unexpected cronjob pod template path: %v", path)
+	}
+}
+
+func TestCustomResourceMutation(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		CustomResources: []CustomResource{
+			{Group: "argoproj.io", Kind: "Rollout", TemplatePaths: []string{"/spec/template"}},
+			{
+				Group:         "example.com",
+				Version:       "v1",
+				Kind:          "WorkerPool",
+				TemplatePaths: []string{"/spec/pools/0/template", "/spec/pools/1/template"},
+			},
+		},
+	}
+
+	response := validateFixture(t, "test_data/rollout-env.json", settings)
+	annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+	if annotations["co_elastic_logs_path"] != "/var/log/app.log" {
+		t.Errorf("Expected annotation co_elastic_logs_path to be /var/log/app.log, got %v",
+			annotations["co_elastic_logs_path"])
+	}
+
+	response = validateFixture(t, "test_data/workerpool-env.json", settings)
+	for i, expectedValue := range []string{"/var/log/small.log", "/var/log/large.log"} {
+		annotations = mutatedMap(t, response, "spec", "pools", strconv.Itoa(i), "template", "metadata", "annotations")
+		if annotations["co_elastic_logs_path"] != expectedValue {
+			t.Errorf("Expected annotation co_elastic_logs_path of pool %d to be %s, got %v",
+				i, expectedValue, annotations["co_elastic_logs_path"])
+		}
+	}
+}
+
+func TestCustomResourceNotConfiguredIsAccepted(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+		CustomResources: []CustomResource{
+			{Group: "argoproj.io", Version: "v1", Kind: "Rollout", TemplatePaths: []string{"/spec/template"}},
+		},
+	}
+
+	response := validateFixture(t, "test_data/rollout-env.json", settings)
+	if !response.Accepted {
+		t.Error("Expected request to be accepted")
+	}
+	assertNoMutation(t, response)
+}
+
+func TestControlledWorkloadIsAccepted(t *testing.T) {
+	// The template of a ReplicaSet owned by a Deployment must stay equal to the Deployment one,
+	// even when the generated values depend on the request
+	settings := Settings{
+		EnvKey:                "vestack_varlog",
+		AnnotationBase:        "co_elastic_logs_path",
+		AnnotationExtFormat:   "co_elastic_logs_path_ext_{{ .Seq }}",
+		TemplateMode:          TemplateModeGoTemplate,
+		AdditionalAnnotations: map[string]interface{}{"example.com/src": "{{ .Kind }}/{{ .Name }}"},
+	}
+
+	response := validateFixture(t, "test_data/replicaset-deployment-owned.json", settings)
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+
+	// ReplicaSets created directly are still annotated
+	response = validateFixture(t, "test_data/replicaset-env.json", settings)
+	annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+	if annotations["example.com/src"] != "ReplicaSet/nginx" {
+		t.Errorf("Expected the ReplicaSet template to be annotated, got %v", annotations)
+	}
+}
+
+func TestJobUpdateIsAccepted(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+	}
+
+	// A Job created before the policy, updated by `kubectl label job`
+	admissionRequest := loadFixture(t, "test_data/job-env.json", nil)
+	admissionRequest.Operation = "UPDATE"
+	admissionRequest.OldObject = admissionRequest.Object
+	request := kubewarden_protocol.ValidationRequest{
+		Request:  admissionRequest,
+		Settings: mustMarshalJSON(settings),
+	}
+	response, err := validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+
+	// The top-level metadata of the Job can still be changed
+	settings.Placement = PlacementWorkload
+	request.Settings = mustMarshalJSON(settings)
+	response, err = validateTest(t, request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations := mutatedMap(t, response, "metadata", "annotations")
+	if annotations["co_elastic_logs_path"] != "/var/log/app.log" {
+		t.Errorf("Expected the Job metadata to be annotated, got %v", annotations)
+	}
+}
+
+func TestWorkloadControlledByOtherOwners(t *testing.T) {
+	settings := Settings{
+		EnvKey:              "vestack_varlog",
+		AnnotationBase:      "co_elastic_logs_path",
+		AnnotationExtFormat: "co_elastic_logs_path_ext_%d",
+	}
+	withController := func(apiVersion, kind string) func(object map[string]interface{}) {
+		return func(object map[string]interface{}) {
+			metadata := object["metadata"].(map[string]interface{})
+			metadata["ownerReferences"] = []interface{}{
+				map[string]interface{}{
+					"apiVersion": apiVersion,
+					"kind":       kind,
+					"name":       "owner",
+					"uid":        "8f4c7c2e-55b4-4f0a-9f4e-2d1c3b5a6e7f",
+					"controller": true,
+				},
+			}
+		}
+	}
+
+	// The templates of the operators are not annotated, their objects are
+	response := validateModifiedFixture(t, "test_data/statefulset-env.json",
+		withController("databases.example.com/v1", "Database"), settings)
+	annotations := mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+	if annotations["co_elastic_logs_path"] != "/var/log/app.log" {
+		t.Errorf("Expected the StatefulSet template to be annotated, got %v", annotations)
+	}
+
+	// Unless the owner is a configured custom resource
+	rollout := withController("argoproj.io/v1alpha1", "Rollout")
+	response = validateModifiedFixture(t, "test_data/replicaset-env.json", rollout, settings)
+	mutatedMap(t, response, "spec", "template", "metadata", "annotations")
+
+	settings.CustomResources = []CustomResource{
+		{Group: "argoproj.io", Kind: "Rollout", TemplatePaths: []string{"/spec/template"}},
+	}
+	response = validateModifiedFixture(t, "test_data/replicaset-env.json", rollout, settings)
+	if !response.Accepted {
+		t.Errorf("Expected request to be accepted, got rejected: %v", response.Message)
+	}
+	assertNoMutation(t, response)
+}
+
+func TestParseJSONPointer(t *testing.T) {
+	path, err := parseJSONPointer("/spec/a~1b/c~0d/0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"spec", "a/b", "c~d", "0"}
+	if strings.Join(path, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %v, got %v", expected, path)
+	}
+
+	for _, pointer := range []string{"", "spec/template"} {
+		if _, err = parseJSONPointer(pointer); err == nil {
+			t.Errorf("Expected an error for JSON pointer %q", pointer)
+		}
+	}
+}